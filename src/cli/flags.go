@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cucumber/cucumber-engine/src/dto"
+)
+
+// stringsFlag is a flag that can be given multiple times
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func parseScheduleOrder(value string) (dto.ScheduleOrder, error) {
+	switch value {
+	case "sources":
+		return dto.ScheduleOrderSources, nil
+	case "longest-first":
+		return dto.ScheduleOrderLongestFirst, nil
+	}
+	return 0, fmt.Errorf("unknown schedule order: %s", value)
+}
+
+func validateWorkers(workers int, listen string) error {
+	if workers < 1 {
+		return fmt.Errorf("--workers must be at least 1: %d", workers)
+	}
+	if workers > 1 && listen == "" {
+		return fmt.Errorf("--workers requires --listen")
+	}
+	return nil
+}
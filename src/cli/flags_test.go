@@ -0,0 +1,27 @@
+package cli
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("validateWorkers", func() {
+	DescribeTable("valid",
+		func(workers int, listen string) {
+			Expect(validateWorkers(workers, listen)).To(Succeed())
+		},
+		Entry("a single worker over stdio", 1, ""),
+		Entry("a single worker with listen", 1, "tcp://localhost:0"),
+		Entry("multiple workers with listen", 2, "tcp://localhost:0"),
+	)
+
+	DescribeTable("invalid",
+		func(workers int, listen string, expectedError string) {
+			Expect(validateWorkers(workers, listen)).To(MatchError(expectedError))
+		},
+		Entry("zero workers", 0, "tcp://localhost:0", "--workers must be at least 1: 0"),
+		Entry("negative workers", -1, "", "--workers must be at least 1: -1"),
+		Entry("multiple workers without listen", 2, "", "--workers requires --listen"),
+	)
+})
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+)
+
+// listen listens on the given address (`unix:///path` or `tcp://host:port`)
+func listen(address string) (net.Listener, error) {
+	network, networkAddress, err := parseListenAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen(network, networkAddress)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "cucumber-engine: listening on %s://%s\n", network, listener.Addr())
+	return listener, nil
+}
+
+// acceptConnections returns the first count connections made to the listener and closes it
+func acceptConnections(listener net.Listener, count int) ([]net.Conn, error) {
+	defer listener.Close()
+	conns := make([]net.Conn, count)
+	for i := range conns {
+		var err error
+		conns[i], err = listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return conns, nil
+}
+
+func parseListenAddress(address string) (string, string, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", err
+	}
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("Missing path in listen address: `%s`", address)
+		}
+		return "unix", u.Path, nil
+	case "tcp":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("Missing host in listen address: `%s`", address)
+		}
+		return "tcp", u.Host, nil
+	default:
+		return "", "", fmt.Errorf("Unexpected listen address: `%s`. Should be `unix:///path` or `tcp://host:port`", address)
+	}
+}
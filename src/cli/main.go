@@ -6,7 +6,11 @@ import (
 	"io"
 	"math"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
+	"github.com/cucumber/cucumber-engine/src/dto"
 	"github.com/cucumber/cucumber-engine/src/runner"
 	messages "github.com/cucumber/cucumber-messages-go/v3"
 	protobufio "github.com/gogo/protobuf/io"
@@ -18,40 +22,176 @@ var version string
 func Execute() {
 	versionFlag := flag.Bool("version", false, "print version")
 	debugFlag := flag.Bool("debug", false, "print debug information")
+	formatFlag := flag.String("format", "protobuf", "wire format of the messages on stdin / stdout: protobuf or ndjson")
+	retryFlag := flag.Int("retry", 0, "number of times to retry failing test cases")
+	retryTagFilterFlag := flag.String("retry-tag-filter", "", "tag expression limiting which test cases are retried")
+	testRunHookTimeoutFlag := flag.Duration("test-run-hook-timeout", 0, "timeout for running the test run hooks")
+	testCaseHookTimeoutFlag := flag.Duration("test-case-hook-timeout", 0, "timeout for running a test case hook")
+	testStepTimeoutFlag := flag.Duration("test-step-timeout", 0, "timeout for running a test step")
+	generateSnippetTimeoutFlag := flag.Duration("generate-snippet-timeout", 0, "timeout for generating a snippet")
+	rerunFileFlag := flag.String("rerun-file", "", "path to write the locations of the failing scenarios to")
+	var exclusiveTagPrefixFlag, exclusiveTagFilterFlag stringsFlag
+	flag.Var(&exclusiveTagPrefixFlag, "exclusive-tag-prefix", "tag prefix, each tag starting with it marks test cases that are not run at the same time, can be repeated")
+	flag.Var(&exclusiveTagFilterFlag, "exclusive-tag-filter", "tag expression matching test cases that are not run at the same time, can be repeated")
+	serialTagFilterFlag := flag.String("serial-tag-filter", "", "tag expression matching test cases that are run alone when running in parallel")
+	timingsFileFlag := flag.String("timings-file", "", "path to read the durations of the test cases of the previous run from and write the durations of this run to")
+	scheduleOrderFlag := flag.String("schedule-order", "sources", "order in which test cases are started when running in parallel: sources or longest-first")
+	shardIndexFlag := flag.Int("shard-index", 0, "zero based index of the shard of the test cases to run, see --shard-total")
+	shardTotalFlag := flag.Int("shard-total", 0, "number of shards to split the test cases into, only the shard given by --shard-index is run")
+	stablePickleIdsFlag := flag.Bool("stable-pickle-ids", false, "derive the pickle ids from the location of the pickles instead of generating random ids")
+	usageFlag := flag.Bool("usage", false, "send a report of the usage of each step definition at the end of the run")
+	transformParametersFlag := flag.Bool("transform-parameters", false, "send the values of the built-in parameter types before running each test step")
+	continueOnParseErrorsFlag := flag.Bool("continue-on-parse-errors", false, "run the sources without parse errors and fail the run instead of failing before running anything")
+	strictStepDefinitionsFlag := flag.Bool("strict-step-definitions", false, "fail when step definitions are duplicates or equivalent instead of warning")
+	workersFlag := flag.Int("workers", 1, "number of worker connections to accept when listening, test cases are run in parallel across them")
+	listenFlag := flag.String("listen", "", "communicate over a single connection to unix:///path or tcp://host:port instead of stdin / stdout")
 	flag.Parse()
 	if *versionFlag {
 		fmt.Printf("cucumber-engine %s\n", version)
 		os.Exit(0)
 	}
-	r := runner.NewRunner()
+	scheduleOrder, err := parseScheduleOrder(*scheduleOrderFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cucumber-engine: %s\n", err)
+		os.Exit(1)
+	}
+	if err := validateWorkers(*workersFlag, *listenFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "cucumber-engine: %s\n", err)
+		os.Exit(1)
+	}
+	var connections []io.ReadWriter
+	if *listenFlag == "" {
+		connections = []io.ReadWriter{stdio{}}
+	} else {
+		listener, err := listen(*listenFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cucumber-engine: %s\n", err)
+			os.Exit(1)
+		}
+		conns, err := acceptConnections(listener, *workersFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cucumber-engine: %s\n", err)
+			os.Exit(1)
+		}
+		for _, conn := range conns {
+			defer conn.Close()
+			connections = append(connections, conn)
+		}
+	}
+	readers := make([]protobufio.Reader, len(connections))
+	writers := make([]protobufio.Writer, len(connections))
+	for i, connection := range connections {
+		var err error
+		readers[i], writers[i], err = getReaderAndWriter(*formatFlag, connection, connection)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cucumber-engine: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	var debugWriter io.Writer
+	if *debugFlag {
+		debugWriter = os.Stderr
+	}
+	r := runner.NewRunner(&dto.EngineConfig{
+		RetryCount:              *retryFlag,
+		RetryTagExpression:      *retryTagFilterFlag,
+		TestRunHookTimeout:      *testRunHookTimeoutFlag,
+		TestCaseHookTimeout:     *testCaseHookTimeoutFlag,
+		TestStepTimeout:         *testStepTimeoutFlag,
+		GenerateSnippetTimeout:  *generateSnippetTimeoutFlag,
+		RerunFilePath:           *rerunFileFlag,
+		ExclusiveTagPrefixes:    exclusiveTagPrefixFlag,
+		ExclusiveTagExpressions: exclusiveTagFilterFlag,
+		SerialTagExpression:     *serialTagFilterFlag,
+		TimingsFilePath:         *timingsFileFlag,
+		ScheduleOrder:           scheduleOrder,
+		ShardIndex:              *shardIndexFlag,
+		ShardTotal:              *shardTotalFlag,
+		StablePickleIds:         *stablePickleIdsFlag,
+		StepDefinitionUsage:     *usageFlag,
+		StrictStepDefinitions:   *strictStepDefinitionsFlag,
+		TransformParameters:     *transformParametersFlag,
+		ContinueOnParseErrors:   *continueOnParseErrorsFlag,
+		DebugWriter:             debugWriter,
+	})
 	incoming, outgoing := r.GetCommandChannels()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		r.Cancel()
+		<-signals
+		fmt.Fprintf(os.Stderr, "cucumber-engine: received a second signal, exiting\n")
+		os.Exit(1)
+	}()
+	serve(incoming, outgoing, readers, writers, debugWriter)
+}
+
+// serve passes the commands of the runner to the workers and their responses back
+// to the runner, until the runner is done and every reader has reached its end
+func serve(incoming, outgoing chan *messages.Envelope, readers []protobufio.Reader, writers []protobufio.Writer, debugWriter io.Writer) {
+	router := newWorkerRouter(writers)
 	done := make(chan bool)
 	go func() {
-		writer := protobufio.NewDelimitedWriter(os.Stdout)
 		for command := range outgoing {
-			if *debugFlag {
-				fmt.Fprintf(os.Stderr, "cucumber-engine OUT: %+v\n", command)
+			if debugWriter != nil {
+				fmt.Fprintf(debugWriter, "cucumber-engine OUT: %+v\n", command)
 			}
-			err := writer.WriteMsg(command)
+			err := router.routeOutgoing(command)
 			if err != nil {
 				panic(err)
 			}
 		}
 		done <- true
 	}()
-	reader := protobufio.NewDelimitedReader(os.Stdin, math.MaxInt32)
-	for {
-		command := &messages.Envelope{}
-		err := reader.ReadMsg(command)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			panic(err)
-		}
-		if *debugFlag {
-			fmt.Fprintf(os.Stderr, "cucumber-engine IN: %+v\n", command)
-		}
-		incoming <- command
+	var readersWaitGroup sync.WaitGroup
+	for _, reader := range readers {
+		readersWaitGroup.Add(1)
+		go func(reader protobufio.Reader) {
+			defer readersWaitGroup.Done()
+			for {
+				command := &messages.Envelope{}
+				err := reader.ReadMsg(command)
+				if err == io.EOF {
+					return
+				} else if err != nil {
+					panic(err)
+				}
+				if debugWriter != nil {
+					fmt.Fprintf(debugWriter, "cucumber-engine IN: %+v\n", command)
+				}
+				routed, err := router.routeIncoming(command)
+				if err != nil {
+					panic(err)
+				}
+				if routed != nil {
+					incoming <- routed
+				}
+			}
+		}(reader)
 	}
+	readersWaitGroup.Wait()
 	<-done
 }
+
+// stdio reads from stdin and writes to stdout
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error) {
+	return os.Stdin.Read(p)
+}
+
+func (stdio) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+func getReaderAndWriter(format string, in io.Reader, out io.Writer) (protobufio.Reader, protobufio.Writer, error) {
+	switch format {
+	case "protobuf":
+		return protobufio.NewDelimitedReader(in, math.MaxInt32), protobufio.NewDelimitedWriter(out), nil
+	case "ndjson":
+		return newNdjsonReader(in), newNdjsonWriter(out), nil
+	default:
+		return nil, nil, fmt.Errorf("Unexpected format: `%s`. Should be `protobuf` or `ndjson`", format)
+	}
+}
@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"runtime"
+	"sync"
+
+	"github.com/cucumber/cucumber-engine/src/dto"
+	"github.com/cucumber/cucumber-engine/src/runner"
+	"github.com/cucumber/cucumber-engine/test/helpers"
+	messages "github.com/cucumber/cucumber-messages-go/v3"
+	protobufio "github.com/gogo/protobuf/io"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("serve", func() {
+	_, filename, _, _ := runtime.Caller(0)
+	rootDir := path.Join(filename, "..", "..", "..")
+	var testRunFinished *messages.TestRunFinished
+	var workerPickleIDs [][]string
+
+	// runWithTwoWorkers runs many.feature with two workers connecting to the address and
+	// returns the test run finished event and the pickle ids of the steps each worker ran
+	runWithTwoWorkers := func(address string) (*messages.TestRunFinished, [][]string) {
+		listener, err := listen(address)
+		Expect(err).NotTo(HaveOccurred())
+		accepted := make(chan []net.Conn)
+		go func() {
+			defer GinkgoRecover()
+			conns, err := acceptConnections(listener, 2)
+			Expect(err).NotTo(HaveOccurred())
+			accepted <- conns
+		}()
+		clients := make([]net.Conn, 2)
+		for i := range clients {
+			clients[i], err = net.Dial(listener.Addr().Network(), listener.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+		}
+		conns := <-accepted
+		readers := make([]protobufio.Reader, len(conns))
+		writers := make([]protobufio.Writer, len(conns))
+		for i, conn := range conns {
+			readers[i], writers[i], err = getReaderAndWriter("ndjson", conn, conn)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		r := runner.NewRunner(&dto.EngineConfig{})
+		incoming, outgoing := r.GetCommandChannels()
+		served := make(chan bool)
+		go func() {
+			serve(incoming, outgoing, readers, writers, nil)
+			served <- true
+		}()
+
+		workerPickleIDs := make([][]string, len(clients))
+		finished := make(chan *messages.TestRunFinished, 1)
+		var workersWaitGroup sync.WaitGroup
+		for i, client := range clients {
+			reader, writer, err := getReaderAndWriter("ndjson", client, client)
+			Expect(err).NotTo(HaveOccurred())
+			if i == 0 {
+				Expect(writer.WriteMsg(&messages.Envelope{
+					Message: &messages.Envelope_CommandStart{
+						CommandStart: &messages.CommandStart{
+							BaseDirectory: rootDir,
+							SourcesConfig: &messages.SourcesConfig{
+								AbsolutePaths: []string{path.Join(rootDir, "test", "fixtures", "many.feature")},
+								Filters:       &messages.SourcesFilterConfig{},
+								Language:      "en",
+								Order:         &messages.SourcesOrder{},
+							},
+							SupportCodeConfig: &messages.SupportCodeConfig{
+								StepDefinitionConfigs: []*messages.StepDefinitionConfig{
+									{
+										Id: "step1",
+										Pattern: &messages.StepDefinitionPattern{
+											Source: "expection$",
+											Type:   messages.StepDefinitionPatternType_REGULAR_EXPRESSION,
+										},
+									},
+								},
+							},
+						},
+					},
+				})).To(Succeed())
+			}
+			workersWaitGroup.Add(1)
+			go func(i int, reader protobufio.Reader, writer protobufio.Writer) {
+				defer GinkgoRecover()
+				defer workersWaitGroup.Done()
+				for {
+					command := &messages.Envelope{}
+					err := reader.ReadMsg(command)
+					if err == io.EOF {
+						return
+					}
+					Expect(err).NotTo(HaveOccurred())
+					switch x := command.Message.(type) {
+					case *messages.Envelope_CommandRunBeforeTestRunHooks:
+						Expect(writer.WriteMsg(helpers.CreateActionCompleteMessage(x.CommandRunBeforeTestRunHooks.ActionId))).To(Succeed())
+					case *messages.Envelope_CommandRunAfterTestRunHooks:
+						Expect(writer.WriteMsg(helpers.CreateActionCompleteMessage(x.CommandRunAfterTestRunHooks.ActionId))).To(Succeed())
+					case *messages.Envelope_CommandInitializeTestCase:
+						Expect(writer.WriteMsg(helpers.CreateActionCompleteMessage(x.CommandInitializeTestCase.ActionId))).To(Succeed())
+					case *messages.Envelope_CommandRunTestStep:
+						workerPickleIDs[i] = append(workerPickleIDs[i], x.CommandRunTestStep.PickleId)
+						Expect(writer.WriteMsg(helpers.CreateActionCompleteMessageWithTestResult(x.CommandRunTestStep.ActionId, &messages.TestResult{Status: messages.TestResult_PASSED}))).To(Succeed())
+					case *messages.Envelope_TestRunFinished:
+						finished <- x.TestRunFinished
+					}
+				}
+			}(i, reader, writer)
+		}
+		testRunFinished := <-finished
+		for _, client := range clients {
+			Expect(client.(interface{ CloseWrite() error }).CloseWrite()).To(Succeed())
+		}
+		Eventually(served).Should(Receive())
+		for _, conn := range conns {
+			Expect(conn.Close()).To(Succeed())
+		}
+		workersWaitGroup.Wait()
+		for _, client := range clients {
+			Expect(client.Close()).To(Succeed())
+		}
+		return testRunFinished, workerPickleIDs
+	}
+
+	itRunsTheTestCasesAcrossBothWorkers := func() {
+		It("runs the test cases across both workers", func() {
+			Expect(testRunFinished).To(Equal(&messages.TestRunFinished{Success: true}))
+			Expect(workerPickleIDs[0]).NotTo(BeEmpty())
+			Expect(workerPickleIDs[1]).NotTo(BeEmpty())
+			Expect(len(workerPickleIDs[0]) + len(workerPickleIDs[1])).To(Equal(5))
+		})
+	}
+
+	Context("with two workers connected over a unix socket", func() {
+		var tmpDir string
+
+		BeforeEach(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "cucumber-engine")
+			Expect(err).NotTo(HaveOccurred())
+			testRunFinished, workerPickleIDs = runWithTwoWorkers("unix://" + path.Join(tmpDir, "engine.sock"))
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tmpDir)).To(Succeed())
+		})
+
+		itRunsTheTestCasesAcrossBothWorkers()
+	})
+
+	Context("with two workers connected over tcp", func() {
+		BeforeEach(func() {
+			testRunFinished, workerPickleIDs = runWithTwoWorkers("tcp://127.0.0.1:0")
+		})
+
+		itRunsTheTestCasesAcrossBothWorkers()
+	})
+})
+
+var _ = Describe("parseListenAddress", func() {
+	DescribeTable("valid",
+		func(address, expectedNetwork, expectedAddress string) {
+			network, networkAddress, err := parseListenAddress(address)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(network).To(Equal(expectedNetwork))
+			Expect(networkAddress).To(Equal(expectedAddress))
+		},
+		Entry("unix socket", "unix:///tmp/engine.sock", "unix", "/tmp/engine.sock"),
+		Entry("tcp host and port", "tcp://localhost:8080", "tcp", "localhost:8080"),
+	)
+
+	DescribeTable("invalid",
+		func(address, expectedError string) {
+			_, _, err := parseListenAddress(address)
+			Expect(err).To(MatchError(expectedError))
+		},
+		Entry("missing scheme", "/tmp/engine.sock", "Unexpected listen address: `/tmp/engine.sock`. Should be `unix:///path` or `tcp://host:port`"),
+		Entry("unknown scheme", "udp://localhost:8080", "Unexpected listen address: `udp://localhost:8080`. Should be `unix:///path` or `tcp://host:port`"),
+		Entry("unix socket without a path", "unix://", "Missing path in listen address: `unix://`"),
+		Entry("tcp without a host", "tcp://", "Missing host in listen address: `tcp://`"),
+	)
+})
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	protobufio "github.com/gogo/protobuf/io"
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/proto"
+)
+
+// newNdjsonWriter returns a writer that writes each message as a single line of json
+func newNdjsonWriter(w io.Writer) protobufio.Writer {
+	return &ndjsonWriter{
+		marshaler: &jsonpb.Marshaler{},
+		writer:    bufio.NewWriter(w),
+	}
+}
+
+type ndjsonWriter struct {
+	marshaler *jsonpb.Marshaler
+	writer    *bufio.Writer
+}
+
+func (n *ndjsonWriter) WriteMsg(msg proto.Message) error {
+	err := n.marshaler.Marshal(n.writer, msg)
+	if err != nil {
+		return err
+	}
+	err = n.writer.WriteByte('\n')
+	if err != nil {
+		return err
+	}
+	return n.writer.Flush()
+}
+
+// newNdjsonReader returns a reader that reads messages from newline delimited json
+func newNdjsonReader(r io.Reader) protobufio.Reader {
+	return &ndjsonReader{
+		decoder: json.NewDecoder(r),
+	}
+}
+
+type ndjsonReader struct {
+	decoder *json.Decoder
+}
+
+func (n *ndjsonReader) ReadMsg(msg proto.Message) error {
+	return jsonpb.UnmarshalNext(n.decoder, msg)
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	messages "github.com/cucumber/cucumber-messages-go/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ndjson", func() {
+	testRunStarted := &messages.Envelope{
+		Message: &messages.Envelope_TestRunStarted{TestRunStarted: &messages.TestRunStarted{}},
+	}
+	testRunFinished := &messages.Envelope{
+		Message: &messages.Envelope_TestRunFinished{TestRunFinished: &messages.TestRunFinished{Success: true}},
+	}
+
+	It("writes each message on its own line", func() {
+		buffer := &bytes.Buffer{}
+		writer := newNdjsonWriter(buffer)
+		Expect(writer.WriteMsg(testRunStarted)).To(Succeed())
+		Expect(writer.WriteMsg(testRunFinished)).To(Succeed())
+		Expect(buffer.String()).To(Equal("{\"testRunStarted\":{}}\n{\"testRunFinished\":{\"success\":true}}\n"))
+	})
+
+	It("reads back the messages it writes and then io.EOF", func() {
+		buffer := &bytes.Buffer{}
+		writer := newNdjsonWriter(buffer)
+		Expect(writer.WriteMsg(testRunStarted)).To(Succeed())
+		Expect(writer.WriteMsg(testRunFinished)).To(Succeed())
+		reader := newNdjsonReader(buffer)
+		for _, expected := range []*messages.Envelope{testRunStarted, testRunFinished} {
+			msg := &messages.Envelope{}
+			Expect(reader.ReadMsg(msg)).To(Succeed())
+			Expect(msg).To(Equal(expected))
+		}
+		Expect(reader.ReadMsg(&messages.Envelope{})).To(Equal(io.EOF))
+	})
+
+	It("reads a last line without a newline", func() {
+		reader := newNdjsonReader(strings.NewReader("{\"testRunStarted\":{}}\n{\"testRunFinished\":{\"success\":true}}"))
+		Expect(reader.ReadMsg(&messages.Envelope{})).To(Succeed())
+		msg := &messages.Envelope{}
+		Expect(reader.ReadMsg(msg)).To(Succeed())
+		Expect(msg).To(Equal(testRunFinished))
+		Expect(reader.ReadMsg(&messages.Envelope{})).To(Equal(io.EOF))
+	})
+
+	It("fails on a truncated last line", func() {
+		reader := newNdjsonReader(strings.NewReader("{\"testRunStarted\":{}}\n{\"testRunFinished\":{\"succ"))
+		Expect(reader.ReadMsg(&messages.Envelope{})).To(Succeed())
+		err := reader.ReadMsg(&messages.Envelope{})
+		Expect(err).To(HaveOccurred())
+		Expect(err).NotTo(Equal(io.EOF))
+	})
+
+	Describe("getReaderAndWriter", func() {
+		for _, format := range []string{"protobuf", "ndjson"} {
+			format := format
+
+			It("round trips messages in the "+format+" format", func() {
+				buffer := &bytes.Buffer{}
+				reader, writer, err := getReaderAndWriter(format, buffer, buffer)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(writer.WriteMsg(testRunStarted)).To(Succeed())
+				Expect(writer.WriteMsg(testRunFinished)).To(Succeed())
+				for _, expected := range []*messages.Envelope{testRunStarted, testRunFinished} {
+					msg := &messages.Envelope{}
+					Expect(reader.ReadMsg(msg)).To(Succeed())
+					Expect(msg).To(Equal(expected))
+				}
+				Expect(reader.ReadMsg(&messages.Envelope{})).To(Equal(io.EOF))
+			})
+		}
+
+		It("fails on an unknown format", func() {
+			_, _, err := getReaderAndWriter("xml", &bytes.Buffer{}, &bytes.Buffer{})
+			Expect(err).To(MatchError("Unexpected format: `xml`. Should be `protobuf` or `ndjson`"))
+		})
+	})
+})
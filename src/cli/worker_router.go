@@ -0,0 +1,237 @@
+package cli
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/cucumber/cucumber-engine/src/dto/event"
+	messages "github.com/cucumber/cucumber-messages-go/v3"
+	protobufio "github.com/gogo/protobuf/io"
+)
+
+// workerRouter routes the commands of a runner between one or more worker connections.
+// The first worker is the primary one: it sends the start command and receives the events.
+// Test cases are assigned to a free worker when they are initialized and all their
+// hooks and steps are sent to that worker. Test run hooks are run on every worker.
+// A worker stays busy until it has responded to the actions that timed out, as long as
+// another worker can take its test cases. Test cases initialized while every worker
+// is busy wait for one to be free
+type workerRouter struct {
+	mutex                     sync.Mutex
+	workers                   []*worker
+	pickleIDToWorker          map[string]int
+	actionIDToWorker          map[string]int
+	timedOutActionIDToWorker  map[string]int
+	testRunHookActionIDToWait map[string]*testRunHookResponses
+	queuedTestCases           []*messages.Envelope
+}
+
+type worker struct {
+	writeMutex        sync.Mutex
+	writer            protobufio.Writer
+	isRunningTestCase bool
+	lateResponseCount int
+}
+
+type testRunHookResponses struct {
+	remaining int
+	response  *messages.Envelope
+}
+
+// workerWrite is a command to write to a worker once the router is unlocked
+type workerWrite struct {
+	worker  int
+	command *messages.Envelope
+}
+
+func newWorkerRouter(writers []protobufio.Writer) *workerRouter {
+	workers := make([]*worker, len(writers))
+	for i, writer := range writers {
+		workers[i] = &worker{writer: writer}
+	}
+	return &workerRouter{
+		workers:                   workers,
+		pickleIDToWorker:          map[string]int{},
+		actionIDToWorker:          map[string]int{},
+		timedOutActionIDToWorker:  map[string]int{},
+		testRunHookActionIDToWait: map[string]*testRunHookResponses{},
+	}
+}
+
+// routeOutgoing writes a command sent by the runner to the worker(s) it is meant for
+func (w *workerRouter) routeOutgoing(command *messages.Envelope) error {
+	w.mutex.Lock()
+	writes := w.getOutgoingWrites(command)
+	w.mutex.Unlock()
+	return w.write(writes)
+}
+
+// routeIncoming returns the command to pass to the runner, if any
+func (w *workerRouter) routeIncoming(command *messages.Envelope) (*messages.Envelope, error) {
+	w.mutex.Lock()
+	routed, writes := w.getIncomingRoute(command)
+	w.mutex.Unlock()
+	return routed, w.write(writes)
+}
+
+func (w *workerRouter) getOutgoingWrites(command *messages.Envelope) []*workerWrite {
+	switch x := command.Message.(type) {
+	case *messages.Envelope_CommandRunBeforeTestRunHooks:
+		return w.getTestRunHookWrites(x.CommandRunBeforeTestRunHooks.ActionId, command)
+	case *messages.Envelope_CommandRunAfterTestRunHooks:
+		return w.getTestRunHookWrites(x.CommandRunAfterTestRunHooks.ActionId, command)
+	case *messages.Envelope_CommandInitializeTestCase:
+		w.queuedTestCases = append(w.queuedTestCases, command)
+		return w.assignQueuedTestCases()
+	case *messages.Envelope_CommandRunBeforeTestCaseHook:
+		return w.getTestCaseActionWrites(x.CommandRunBeforeTestCaseHook.PickleId, x.CommandRunBeforeTestCaseHook.ActionId, command)
+	case *messages.Envelope_CommandRunAfterTestCaseHook:
+		return w.getTestCaseActionWrites(x.CommandRunAfterTestCaseHook.PickleId, x.CommandRunAfterTestCaseHook.ActionId, command)
+	case *messages.Envelope_CommandRunTestStep:
+		return w.getTestCaseActionWrites(x.CommandRunTestStep.PickleId, x.CommandRunTestStep.ActionId, command)
+	case *messages.Envelope_TestCaseFinished:
+		writes := []*workerWrite{{worker: 0, command: command}}
+		if worker, ok := w.pickleIDToWorker[x.TestCaseFinished.PickleId]; ok {
+			w.workers[worker].isRunningTestCase = false
+			delete(w.pickleIDToWorker, x.TestCaseFinished.PickleId)
+			writes = append(writes, w.assignQueuedTestCases()...)
+		}
+		return writes
+	case *messages.Envelope_Attachment:
+		if x.Attachment.GetMedia().GetContentType() == event.ActionTimeoutContentType {
+			w.recordActionTimeout(x.Attachment.Data)
+		}
+	}
+	return []*workerWrite{{worker: 0, command: command}}
+}
+
+func (w *workerRouter) getIncomingRoute(command *messages.Envelope) (*messages.Envelope, []*workerWrite) {
+	switch x := command.Message.(type) {
+	case *messages.Envelope_CommandStart:
+		if len(w.workers) > 1 {
+			if x.CommandStart.RuntimeConfig == nil {
+				x.CommandStart.RuntimeConfig = &messages.RuntimeConfig{}
+			}
+			maxParallel := uint64(len(w.workers))
+			if x.CommandStart.RuntimeConfig.MaxParallel == 0 || x.CommandStart.RuntimeConfig.MaxParallel > maxParallel {
+				x.CommandStart.RuntimeConfig.MaxParallel = maxParallel
+			}
+		}
+	case *messages.Envelope_CommandActionComplete:
+		actionID := x.CommandActionComplete.CompletedId
+		if worker, ok := w.timedOutActionIDToWorker[actionID]; ok {
+			// the runner has moved on, the worker is free once it has caught up
+			delete(w.timedOutActionIDToWorker, actionID)
+			w.workers[worker].lateResponseCount--
+			return nil, w.assignQueuedTestCases()
+		}
+		delete(w.actionIDToWorker, actionID)
+		responses, ok := w.testRunHookActionIDToWait[actionID]
+		if !ok {
+			return command, nil
+		}
+		if responses.response == nil || isFailingActionComplete(x.CommandActionComplete) {
+			responses.response = command
+		}
+		responses.remaining--
+		if responses.remaining > 0 {
+			return nil, nil
+		}
+		delete(w.testRunHookActionIDToWait, actionID)
+		return responses.response, nil
+	}
+	return command, nil
+}
+
+func (w *workerRouter) getTestRunHookWrites(actionID string, command *messages.Envelope) []*workerWrite {
+	w.testRunHookActionIDToWait[actionID] = &testRunHookResponses{remaining: len(w.workers)}
+	writes := make([]*workerWrite, len(w.workers))
+	for worker := range w.workers {
+		writes[worker] = &workerWrite{worker: worker, command: command}
+	}
+	return writes
+}
+
+func (w *workerRouter) getTestCaseActionWrites(pickleID, actionID string, command *messages.Envelope) []*workerWrite {
+	worker := w.pickleIDToWorker[pickleID]
+	w.actionIDToWorker[actionID] = worker
+	return []*workerWrite{{worker: worker, command: command}}
+}
+
+// assignQueuedTestCases assigns the queued test cases to the free workers, in order
+func (w *workerRouter) assignQueuedTestCases() []*workerWrite {
+	writes := []*workerWrite{}
+	for len(w.queuedTestCases) > 0 {
+		worker := w.getFreeWorker()
+		if worker == -1 {
+			break
+		}
+		command := w.queuedTestCases[0]
+		w.queuedTestCases = w.queuedTestCases[1:]
+		initializeTestCase := command.GetCommandInitializeTestCase()
+		w.workers[worker].isRunningTestCase = true
+		w.pickleIDToWorker[initializeTestCase.Pickle.Id] = worker
+		w.actionIDToWorker[initializeTestCase.ActionId] = worker
+		writes = append(writes, &workerWrite{worker: worker, command: command})
+	}
+	return writes
+}
+
+// getFreeWorker returns the index of a worker that is not running a test case, preferring
+// the ones without late responses, or -1 if the test case has to wait. A worker with late
+// responses is only used if every worker has some, as they may never arrive
+func (w *workerRouter) getFreeWorker() int {
+	withLateResponses := -1
+	allHaveLateResponses := true
+	for index, worker := range w.workers {
+		if worker.lateResponseCount > 0 {
+			if !worker.isRunningTestCase && withLateResponses == -1 {
+				withLateResponses = index
+			}
+			continue
+		}
+		allHaveLateResponses = false
+		if !worker.isRunningTestCase {
+			return index
+		}
+	}
+	if allHaveLateResponses {
+		return withLateResponses
+	}
+	return -1
+}
+
+// recordActionTimeout keeps the worker of the timed out action busy until it responds.
+// A test run hook that timed out no longer waits for the responses of the workers
+func (w *workerRouter) recordActionTimeout(data string) {
+	actionTimeout := &event.ActionTimeout{}
+	if err := json.Unmarshal([]byte(data), actionTimeout); err != nil {
+		return
+	}
+	if worker, ok := w.actionIDToWorker[actionTimeout.ActionID]; ok {
+		delete(w.actionIDToWorker, actionTimeout.ActionID)
+		w.timedOutActionIDToWorker[actionTimeout.ActionID] = worker
+		w.workers[worker].lateResponseCount++
+	}
+	delete(w.testRunHookActionIDToWait, actionTimeout.ActionID)
+}
+
+// write writes the commands to the workers. Each worker is locked separately as
+// commands can be written from both the outgoing and the incoming side
+func (w *workerRouter) write(writes []*workerWrite) error {
+	for _, write := range writes {
+		worker := w.workers[write.worker]
+		worker.writeMutex.Lock()
+		err := worker.writer.WriteMsg(write.command)
+		worker.writeMutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isFailingActionComplete(actionComplete *messages.CommandActionComplete) bool {
+	testResult := actionComplete.GetTestResult()
+	return testResult != nil && testResult.Status != messages.TestResult_PASSED
+}
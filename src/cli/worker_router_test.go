@@ -0,0 +1,188 @@
+package cli
+
+import (
+	"github.com/cucumber/cucumber-engine/src/dto/event"
+	"github.com/cucumber/cucumber-engine/test/helpers"
+	messages "github.com/cucumber/cucumber-messages-go/v3"
+	protobufio "github.com/gogo/protobuf/io"
+	"github.com/gogo/protobuf/proto"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeWriter struct {
+	written []proto.Message
+}
+
+func (f *fakeWriter) WriteMsg(msg proto.Message) error {
+	f.written = append(f.written, msg)
+	return nil
+}
+
+var _ = Describe("workerRouter", func() {
+	var router *workerRouter
+	var workers []*fakeWriter
+
+	BeforeEach(func() {
+		workers = []*fakeWriter{{}, {}}
+		router = newWorkerRouter([]protobufio.Writer{workers[0], workers[1]})
+	})
+
+	routeIncoming := func(command *messages.Envelope) *messages.Envelope {
+		routed, err := router.routeIncoming(command)
+		Expect(err).NotTo(HaveOccurred())
+		return routed
+	}
+
+	initializeTestCase := func(pickleID string) {
+		Expect(router.routeOutgoing(&messages.Envelope{
+			Message: &messages.Envelope_CommandInitializeTestCase{
+				CommandInitializeTestCase: &messages.CommandInitializeTestCase{
+					ActionId: "initialize-" + pickleID,
+					Pickle:   &messages.Pickle{Id: pickleID},
+				},
+			},
+		})).To(Succeed())
+	}
+
+	finishTestCase := func(pickleID string) {
+		Expect(router.routeOutgoing(&messages.Envelope{
+			Message: &messages.Envelope_TestCaseFinished{
+				TestCaseFinished: &messages.TestCaseFinished{PickleId: pickleID},
+			},
+		})).To(Succeed())
+	}
+
+	timeOut := func(actionID string) {
+		attachment, err := event.NewJSONAttachment(event.ActionTimeoutContentType, &event.ActionTimeout{ActionID: actionID})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(router.routeOutgoing(&messages.Envelope{
+			Message: &messages.Envelope_Attachment{Attachment: attachment},
+		})).To(Succeed())
+	}
+
+	It("limits maxParallel to the number of workers", func() {
+		command := routeIncoming(&messages.Envelope{
+			Message: &messages.Envelope_CommandStart{
+				CommandStart: &messages.CommandStart{
+					RuntimeConfig: &messages.RuntimeConfig{MaxParallel: 0},
+				},
+			},
+		})
+		Expect(command.GetCommandStart().RuntimeConfig.MaxParallel).To(Equal(uint64(2)))
+	})
+
+	It("limits maxParallel to the number of workers without a runtime config", func() {
+		command := routeIncoming(&messages.Envelope{
+			Message: &messages.Envelope_CommandStart{
+				CommandStart: &messages.CommandStart{},
+			},
+		})
+		Expect(command.GetCommandStart().RuntimeConfig.MaxParallel).To(Equal(uint64(2)))
+	})
+
+	It("sends the events to the first worker", func() {
+		Expect(router.routeOutgoing(&messages.Envelope{
+			Message: &messages.Envelope_TestRunStarted{TestRunStarted: &messages.TestRunStarted{}},
+		})).To(Succeed())
+		Expect(workers[0].written).To(HaveLen(1))
+		Expect(workers[1].written).To(BeEmpty())
+	})
+
+	It("sends the commands of a test case to the worker it is assigned to", func() {
+		for _, pickleID := range []string{"pickle1", "pickle2"} {
+			Expect(router.routeOutgoing(&messages.Envelope{
+				Message: &messages.Envelope_CommandInitializeTestCase{
+					CommandInitializeTestCase: &messages.CommandInitializeTestCase{
+						Pickle: &messages.Pickle{Id: pickleID},
+					},
+				},
+			})).To(Succeed())
+		}
+		Expect(router.routeOutgoing(&messages.Envelope{
+			Message: &messages.Envelope_CommandRunTestStep{
+				CommandRunTestStep: &messages.CommandRunTestStep{PickleId: "pickle2"},
+			},
+		})).To(Succeed())
+		Expect(workers[0].written).To(HaveLen(1))
+		Expect(workers[1].written).To(HaveLen(2))
+		Expect(workers[1].written[1].(*messages.Envelope).GetCommandRunTestStep().PickleId).To(Equal("pickle2"))
+	})
+
+	It("frees the worker once its test case finishes", func() {
+		initializeTestCase("pickle1")
+		initializeTestCase("pickle2")
+		finishTestCase("pickle2")
+		initializeTestCase("pickle3")
+		Expect(workers[1].written).To(HaveLen(2))
+		Expect(workers[1].written[1].(*messages.Envelope).GetCommandInitializeTestCase().Pickle.Id).To(Equal("pickle3"))
+	})
+
+	It("queues a test case until a worker is free", func() {
+		initializeTestCase("pickle1")
+		initializeTestCase("pickle2")
+		initializeTestCase("pickle3")
+		Expect(workers[0].written).To(HaveLen(1))
+		Expect(workers[1].written).To(HaveLen(1))
+		finishTestCase("pickle1")
+		Expect(workers[0].written).To(HaveLen(3))
+		Expect(workers[0].written[2].(*messages.Envelope).GetCommandInitializeTestCase().Pickle.Id).To(Equal("pickle3"))
+	})
+
+	It("keeps a worker busy until it responds to a timed out step", func() {
+		initializeTestCase("pickle1")
+		initializeTestCase("pickle2")
+		Expect(router.routeOutgoing(&messages.Envelope{
+			Message: &messages.Envelope_CommandRunTestStep{
+				CommandRunTestStep: &messages.CommandRunTestStep{ActionId: "step1", PickleId: "pickle2"},
+			},
+		})).To(Succeed())
+		timeOut("step1")
+		finishTestCase("pickle2")
+		initializeTestCase("pickle3")
+		Expect(workers[1].written).To(HaveLen(2))
+		Expect(routeIncoming(helpers.CreateActionCompleteMessageWithTestResult("step1", &messages.TestResult{Status: messages.TestResult_PASSED}))).To(BeNil())
+		Expect(workers[1].written).To(HaveLen(3))
+		Expect(workers[1].written[2].(*messages.Envelope).GetCommandInitializeTestCase().Pickle.Id).To(Equal("pickle3"))
+	})
+
+	It("keeps using a single worker that has not responded to a timed out step", func() {
+		router = newWorkerRouter([]protobufio.Writer{workers[0]})
+		initializeTestCase("pickle1")
+		Expect(router.routeOutgoing(&messages.Envelope{
+			Message: &messages.Envelope_CommandRunTestStep{
+				CommandRunTestStep: &messages.CommandRunTestStep{ActionId: "step1", PickleId: "pickle1"},
+			},
+		})).To(Succeed())
+		timeOut("step1")
+		finishTestCase("pickle1")
+		initializeTestCase("pickle2")
+		Expect(workers[0].written).To(HaveLen(5))
+		Expect(workers[0].written[4].(*messages.Envelope).GetCommandInitializeTestCase().Pickle.Id).To(Equal("pickle2"))
+	})
+
+	It("runs test run hooks on every worker and responds once all have completed", func() {
+		Expect(router.routeOutgoing(&messages.Envelope{
+			Message: &messages.Envelope_CommandRunBeforeTestRunHooks{
+				CommandRunBeforeTestRunHooks: &messages.CommandRunBeforeTestRunHooks{ActionId: "action1"},
+			},
+		})).To(Succeed())
+		Expect(workers[0].written).To(HaveLen(1))
+		Expect(workers[1].written).To(HaveLen(1))
+		failed := helpers.CreateActionCompleteMessageWithTestResult("action1", &messages.TestResult{Status: messages.TestResult_FAILED})
+		Expect(routeIncoming(failed)).To(BeNil())
+		Expect(routeIncoming(helpers.CreateActionCompleteMessage("action1"))).To(Equal(failed))
+	})
+
+	It("stops waiting for the responses of test run hooks that timed out", func() {
+		Expect(router.routeOutgoing(&messages.Envelope{
+			Message: &messages.Envelope_CommandRunBeforeTestRunHooks{
+				CommandRunBeforeTestRunHooks: &messages.CommandRunBeforeTestRunHooks{ActionId: "action1"},
+			},
+		})).To(Succeed())
+		timeOut("action1")
+		Expect(router.testRunHookActionIDToWait).To(BeEmpty())
+		late := helpers.CreateActionCompleteMessage("action1")
+		Expect(routeIncoming(late)).To(Equal(late))
+	})
+})
@@ -0,0 +1,74 @@
+package dto
+
+import (
+	"io"
+	"time"
+)
+
+// ScheduleOrder is the order in which test cases are started when running in parallel
+type ScheduleOrder int
+
+// The schedule orders
+const (
+	// ScheduleOrderSources keeps the order given by the sources config
+	ScheduleOrderSources ScheduleOrder = iota
+	// ScheduleOrderLongestFirst starts the test cases that took the longest
+	// in the previous run first, using the timings file
+	ScheduleOrderLongestFirst
+)
+
+// EngineConfig is the configuration for engine features that are not
+// part of the RuntimeConfig message
+type EngineConfig struct {
+	// RetryCount is the number of times a failing test case is retried
+	RetryCount int
+	// RetryTagExpression limits retries to the test cases that match it
+	// if empty, all test cases are retryable
+	RetryTagExpression string
+	// Timeouts for the caller to complete an action, zero means no timeout
+	TestRunHookTimeout     time.Duration
+	TestCaseHookTimeout    time.Duration
+	TestStepTimeout        time.Duration
+	GenerateSnippetTimeout time.Duration
+	// RerunFilePath is where the locations of the failing scenarios are written
+	// at the end of the run, if empty no rerun file is written
+	RerunFilePath string
+	// ExclusiveTagPrefixes and ExclusiveTagExpressions define groups of test cases
+	// that are never run at the same time when running in parallel.
+	// Each tag starting with one of the prefixes is a group, for example
+	// `@exclusive:database` with the prefix `@exclusive:`, and each tag expression is a group
+	ExclusiveTagPrefixes    []string
+	ExclusiveTagExpressions []string
+	// SerialTagExpression matches the test cases that are run alone when running in parallel,
+	// empty means none
+	SerialTagExpression string
+	// TimingsFilePath is a file with the duration of each test case. It is read at the
+	// start of the run, if it exists, and updated at the end of the run
+	TimingsFilePath string
+	// ScheduleOrder is the order in which test cases are started when running in parallel
+	ScheduleOrder ScheduleOrder
+	// ShardIndex and ShardTotal split the accepted test cases into ShardTotal parts and
+	// only run the part with the zero based ShardIndex. Zero ShardTotal means no sharding.
+	// If a timings file is given, the parts are balanced by duration, otherwise
+	// test cases are assigned by a hash of their location
+	ShardIndex int
+	ShardTotal int
+	// StablePickleIds derives the pickle ids from the uri and location lines of the
+	// pickles, so a scenario has the same id across runs, instead of random ids
+	StablePickleIds bool
+	// StepDefinitionUsage sends a report of the pickle steps that matched each
+	// step definition at the end of the run
+	StepDefinitionUsage bool
+	// StrictStepDefinitions makes step definitions that match the same steps an error
+	// instead of a warning
+	StrictStepDefinitions bool
+	// TransformParameters sends the values of the built-in parameter types, converted
+	// by the engine, before running each test step
+	TransformParameters bool
+	// ContinueOnParseErrors runs the test cases of the sources without parse errors
+	// and fails the test run, instead of failing before running anything
+	ContinueOnParseErrors bool
+	// DebugWriter receives debug information about the run, such as the step match
+	// cache statistics. If nil, no debug information is written
+	DebugWriter io.Writer
+}
@@ -0,0 +1,9 @@
+package event
+
+// AmbiguousStep lists the step definitions that match a step. Sent before the
+// TestStepFinished of the step, which has the same pickle id and index
+type AmbiguousStep struct {
+	PickleID        string                     `json:"pickleId"`
+	Index           int                        `json:"index"`
+	StepDefinitions []*StepDefinitionReference `json:"stepDefinitions"`
+}
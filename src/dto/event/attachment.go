@@ -0,0 +1,61 @@
+package event
+
+import (
+	"encoding/json"
+
+	messages "github.com/cucumber/cucumber-messages-go/v3"
+)
+
+// Media types of the engine specific attachments
+const (
+	TestCaseAttemptContentType       = "application/x.cucumber-engine.test-case-attempt+json"
+	TestCaseRetriedContentType       = "application/x.cucumber-engine.test-case-retried+json"
+	ActionTimeoutContentType         = "application/x.cucumber-engine.action-timeout+json"
+	TestRunCancelledContentType      = "application/x.cucumber-engine.test-run-cancelled+json"
+	CancelTestRunContentType         = "application/x.cucumber-engine.cancel-test-run+json"
+	TestRunSummaryContentType        = "application/x.cucumber-engine.test-run-summary+json"
+	TestCasePreparedContentType      = "application/x.cucumber-engine.test-case-prepared+json"
+	AmbiguousStepContentType         = "application/x.cucumber-engine.ambiguous-step+json"
+	StepDefinitionUsageContentType   = "application/x.cucumber-engine.step-definition-usage+json"
+	StepDefinitionWarningContentType = "application/x.cucumber-engine.step-definition-warning+json"
+	UndefinedStepContentType         = "application/x.cucumber-engine.undefined-step+json"
+	ParameterValuesContentType       = "application/x.cucumber-engine.parameter-values+json"
+	SupportCodeErrorsContentType     = "application/x.cucumber-engine.support-code-errors+json"
+)
+
+// TestCaseAttempt describes an attempt of running a test case when retries are enabled.
+// It is sent after the test case started event of the attempt and, with the retried
+// content type, after the test case finished event of an attempt that will be retried
+type TestCaseAttempt struct {
+	PickleID string `json:"pickleId"`
+	Attempt  int    `json:"attempt"`
+}
+
+// ActionTimeout describes an action the caller did not complete in time
+type ActionTimeout struct {
+	ActionID            string `json:"actionId"`
+	Action              string `json:"action"`
+	TimeoutMilliseconds int64  `json:"timeoutMilliseconds"`
+}
+
+// TestRunCancelled is sent before the TestRunFinished of a cancelled test run
+type TestRunCancelled struct{}
+
+// CancelTestRun is sent by the caller to cancel the test run
+type CancelTestRun struct{}
+
+// NewJSONAttachment creates an Attachment with the given content type whose data is
+// the json encoding of the given value
+func NewJSONAttachment(contentType string, value interface{}) (*messages.Attachment, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return &messages.Attachment{
+		Data: string(data),
+		Media: &messages.Media{
+			ContentType: contentType,
+			Encoding:    messages.Media_UTF8,
+		},
+	}, nil
+}
@@ -0,0 +1,10 @@
+package event
+
+// ParameterValues has the values of the parameters of a test step, converted by the engine
+// for the built-in parameter types. Sent before the CommandRunTestStep of the step
+type ParameterValues struct {
+	PickleID         string        `json:"pickleId"`
+	Index            int           `json:"index"`
+	StepDefinitionID string        `json:"stepDefinitionId"`
+	Values           []interface{} `json:"values"`
+}
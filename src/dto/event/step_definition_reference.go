@@ -0,0 +1,33 @@
+package event
+
+import "github.com/cucumber/cucumber-engine/src/dto"
+
+// StepDefinitionReference identifies a step definition in an attachment.
+// The uri is relative to the base directory
+type StepDefinitionReference struct {
+	ID            string `json:"id"`
+	PatternSource string `json:"patternSource"`
+	PatternType   string `json:"patternType"`
+	URI           string `json:"uri,omitempty"`
+	Line          uint32 `json:"line,omitempty"`
+}
+
+// StepDefinitionWarning describes step definitions that match the same steps,
+// found when loading the support code. The kind is duplicate, equivalent or overlap
+type StepDefinitionWarning struct {
+	Kind            string                     `json:"kind"`
+	Message         string                     `json:"message"`
+	StepDefinitions []*StepDefinitionReference `json:"stepDefinitions"`
+}
+
+// NewStepDefinitionReference creates a StepDefinitionReference with the given uri,
+// which is the uri of the step definition relative to the base directory
+func NewStepDefinitionReference(stepDefinition *dto.StepDefinition, uri string) *StepDefinitionReference {
+	return &StepDefinitionReference{
+		ID:            stepDefinition.Config.Id,
+		PatternSource: stepDefinition.Config.GetPattern().GetSource(),
+		PatternType:   stepDefinition.Config.GetPattern().GetType().String(),
+		URI:           uri,
+		Line:          stepDefinition.Config.GetLocation().GetLocation().GetLine(),
+	}
+}
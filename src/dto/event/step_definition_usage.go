@@ -0,0 +1,30 @@
+package event
+
+// StepDefinitionUsage lists each step definition with the pickle steps that
+// matched it. Sent at the end of the test run when enabled
+type StepDefinitionUsage struct {
+	StepDefinitions []*StepDefinitionUsageEntry `json:"stepDefinitions"`
+}
+
+// StepDefinitionUsageEntry is the usage of a step definition. The durations are
+// of the matches that ran (passed, failed or pending)
+type StepDefinitionUsageEntry struct {
+	*StepDefinitionReference
+	Unused                  bool                        `json:"unused,omitempty"`
+	MatchCount              int                         `json:"matchCount"`
+	MeanDurationNanoseconds uint64                      `json:"meanDurationNanoseconds"`
+	MaxDurationNanoseconds  uint64                      `json:"maxDurationNanoseconds"`
+	Matches                 []*StepDefinitionUsageMatch `json:"matches"`
+}
+
+// StepDefinitionUsageMatch is a pickle step that matched a step definition.
+// The uri is relative to the base directory. The status and duration are those
+// of the final attempt of the test case
+type StepDefinitionUsageMatch struct {
+	PickleID            string `json:"pickleId"`
+	URI                 string `json:"uri"`
+	Line                uint32 `json:"line"`
+	Text                string `json:"text"`
+	Status              string `json:"status"`
+	DurationNanoseconds uint64 `json:"durationNanoseconds"`
+}
@@ -0,0 +1,21 @@
+package event
+
+// SupportCodeErrors lists the invalid definitions of the support code config.
+// Sent before the error that ends the run
+type SupportCodeErrors struct {
+	Errors []*SupportCodeError `json:"errors"`
+}
+
+// SupportCodeError is an invalid definition of the support code config. The kind is
+// parameterType, beforeTestCaseHookDefinition, afterTestCaseHookDefinition or stepDefinition.
+// The uri is relative to the base directory
+type SupportCodeError struct {
+	Kind              string `json:"kind"`
+	ID                string `json:"id,omitempty"`
+	ParameterTypeName string `json:"parameterTypeName,omitempty"`
+	PatternSource     string `json:"patternSource,omitempty"`
+	TagExpression     string `json:"tagExpression,omitempty"`
+	URI               string `json:"uri,omitempty"`
+	Line              uint32 `json:"line,omitempty"`
+	Message           string `json:"message"`
+}
@@ -11,6 +11,30 @@ type NewTestCasePreparedOptions struct {
 	BeforeTestCaseHookDefinitions []*dto.TestCaseHookDefinition
 	AfterTestCaseHookDefinitions  []*dto.TestCaseHookDefinition
 	StepIndexToStepDefinitions    [][]*dto.StepDefinition
+	StepIndexToPatternMatches     [][]*messages.PatternMatch
+	StepIndexToPatternMatchGroups [][]*dto.PatternMatchGroup
+}
+
+// TestCasePreparedDetails describes the steps of a TestCasePrepared with the
+// definitions they run. Sent right after the TestCasePrepared
+type TestCasePreparedDetails struct {
+	PickleID string                         `json:"pickleId"`
+	Steps    []*TestCasePreparedDetailsStep `json:"steps"`
+}
+
+// TestCasePreparedDetailsStep is a step of a TestCasePreparedDetails, in the same
+// order as the steps of the TestCasePrepared. Hook steps have the test case hook
+// definition id, pickle steps have the index of the pickle step, the ids of the matching
+// step definitions and the pattern matches if there is exactly one. The pattern match
+// groups have the position in the step text of each pattern match
+type TestCasePreparedDetailsStep struct {
+	TestCaseHookDefinitionID string                   `json:"testCaseHookDefinitionId,omitempty"`
+	PickleStepIndex          *int                     `json:"pickleStepIndex,omitempty"`
+	StepDefinitionIDs        []string                 `json:"stepDefinitionIds,omitempty"`
+	PatternMatches           []*messages.PatternMatch `json:"patternMatches,omitempty"`
+	PatternMatchGroups       []*dto.PatternMatchGroup `json:"patternMatchGroups,omitempty"`
+	Undefined                bool                     `json:"undefined,omitempty"`
+	Ambiguous                bool                     `json:"ambiguous,omitempty"`
 }
 
 // NewTestCasePrepared creates a TestCasePrepared
@@ -39,7 +63,45 @@ func NewTestCasePrepared(opts NewTestCasePreparedOptions) *messages.TestCasePrep
 		})
 	}
 	return &messages.TestCasePrepared{
-		PickleId: opts.Pickle.Name, // TODO fix
+		PickleId: opts.Pickle.Id,
+		Steps:    steps,
+	}
+}
+
+// NewTestCasePreparedDetails creates the TestCasePreparedDetails for the same options
+func NewTestCasePreparedDetails(opts NewTestCasePreparedOptions) *TestCasePreparedDetails {
+	steps := []*TestCasePreparedDetailsStep{}
+	for _, def := range opts.BeforeTestCaseHookDefinitions {
+		steps = append(steps, &TestCasePreparedDetailsStep{
+			TestCaseHookDefinitionID: def.Config.Id,
+		})
+	}
+	for stepIndex := range opts.Pickle.Steps {
+		pickleStepIndex := stepIndex
+		stepDefinitions := opts.StepIndexToStepDefinitions[stepIndex]
+		step := &TestCasePreparedDetailsStep{
+			PickleStepIndex: &pickleStepIndex,
+			Undefined:       len(stepDefinitions) == 0,
+			Ambiguous:       len(stepDefinitions) > 1,
+		}
+		for _, def := range stepDefinitions {
+			step.StepDefinitionIDs = append(step.StepDefinitionIDs, def.Config.Id)
+		}
+		if len(stepDefinitions) == 1 && stepIndex < len(opts.StepIndexToPatternMatches) {
+			step.PatternMatches = opts.StepIndexToPatternMatches[stepIndex]
+		}
+		if len(stepDefinitions) == 1 && stepIndex < len(opts.StepIndexToPatternMatchGroups) {
+			step.PatternMatchGroups = opts.StepIndexToPatternMatchGroups[stepIndex]
+		}
+		steps = append(steps, step)
+	}
+	for _, def := range opts.AfterTestCaseHookDefinitions {
+		steps = append(steps, &TestCasePreparedDetailsStep{
+			TestCaseHookDefinitionID: def.Config.Id,
+		})
+	}
+	return &TestCasePreparedDetails{
+		PickleID: opts.Pickle.Id,
 		Steps:    steps,
 	}
 }
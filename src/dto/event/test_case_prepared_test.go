@@ -9,6 +9,13 @@ import (
 )
 
 var _ = Describe("NewTestCasePrepared", func() {
+	It("has the id of the pickle", func() {
+		testCasePrepared := event.NewTestCasePrepared(event.NewTestCasePreparedOptions{
+			Pickle: &messages.Pickle{Id: "pickle1", Name: "a", Uri: "a.feature"},
+		})
+		Expect(testCasePrepared.PickleId).To(Equal("pickle1"))
+	})
+
 	Context("with no steps/hooks", func() {
 		It("the event has no steps", func() {
 			testCasePrepared := event.NewTestCasePrepared(event.NewTestCasePreparedOptions{
@@ -193,3 +200,55 @@ var _ = Describe("NewTestCasePrepared", func() {
 		})
 	})
 })
+
+var _ = Describe("NewTestCasePreparedDetails", func() {
+	It("describes the hooks and steps", func() {
+		stepIndex0 := 0
+		stepIndex1 := 1
+		stepIndex2 := 2
+		details := event.NewTestCasePreparedDetails(event.NewTestCasePreparedOptions{
+			Pickle: &messages.Pickle{
+				Id: "pickle1",
+				Steps: []*messages.Pickle_PickleStep{
+					{Locations: []*messages.Location{{Line: 2}}},
+					{Locations: []*messages.Location{{Line: 3}}},
+					{Locations: []*messages.Location{{Line: 4}}},
+				},
+				Uri: "a.feature",
+			},
+			BeforeTestCaseHookDefinitions: []*dto.TestCaseHookDefinition{
+				{Config: &messages.TestCaseHookDefinitionConfig{Id: "hook1"}},
+			},
+			AfterTestCaseHookDefinitions: []*dto.TestCaseHookDefinition{
+				{Config: &messages.TestCaseHookDefinitionConfig{Id: "hook2"}},
+			},
+			StepIndexToStepDefinitions: [][]*dto.StepDefinition{
+				{},
+				{{Config: &messages.StepDefinitionConfig{Id: "step1"}}},
+				{
+					{Config: &messages.StepDefinitionConfig{Id: "step1"}},
+					{Config: &messages.StepDefinitionConfig{Id: "step2"}},
+				},
+			},
+			StepIndexToPatternMatches: [][]*messages.PatternMatch{
+				nil,
+				{{Captures: []string{"1"}, ParameterTypeName: "int"}},
+				nil,
+			},
+		})
+		Expect(details).To(Equal(&event.TestCasePreparedDetails{
+			PickleID: "pickle1",
+			Steps: []*event.TestCasePreparedDetailsStep{
+				{TestCaseHookDefinitionID: "hook1"},
+				{PickleStepIndex: &stepIndex0, Undefined: true},
+				{
+					PickleStepIndex:   &stepIndex1,
+					StepDefinitionIDs: []string{"step1"},
+					PatternMatches:    []*messages.PatternMatch{{Captures: []string{"1"}, ParameterTypeName: "int"}},
+				},
+				{PickleStepIndex: &stepIndex2, StepDefinitionIDs: []string{"step1", "step2"}, Ambiguous: true},
+				{TestCaseHookDefinitionID: "hook2"},
+			},
+		}))
+	})
+})
@@ -0,0 +1,19 @@
+package event
+
+// UndefinedStep lists the step definitions that almost match an undefined step. Sent before
+// the TestStepFinished of the step, which has the same pickle id and index, if there are any
+type UndefinedStep struct {
+	PickleID    string                      `json:"pickleId"`
+	Index       int                         `json:"index"`
+	Suggestions []*StepDefinitionSuggestion `json:"suggestions"`
+}
+
+// StepDefinitionSuggestion is a step definition that almost matches a step.
+// The kind is "parameter" if the pattern matches when any parameter is accepted
+// and "text" if the text is a few edits away from the pattern. The distance is the
+// number of edits to the closest example text of the pattern, nil if the pattern has none
+type StepDefinitionSuggestion struct {
+	*StepDefinitionReference
+	Kind     string `json:"kind"`
+	Distance *int   `json:"distance,omitempty"`
+}
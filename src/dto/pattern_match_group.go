@@ -0,0 +1,26 @@
+package dto
+
+import cucumberexpressions "github.com/cucumber/cucumber-expressions-go"
+
+// PatternMatchGroup is a capture group of a pattern match with the byte offsets of
+// its value in the step text and its nested capture groups. A group that did not
+// participate in the match has no value and offsets of -1
+type PatternMatchGroup struct {
+	Value    *string              `json:"value"`
+	Start    int                  `json:"start"`
+	End      int                  `json:"end"`
+	Children []*PatternMatchGroup `json:"children,omitempty"`
+}
+
+// NewPatternMatchGroup converts the group of a cucumber expression argument
+func NewPatternMatchGroup(group *cucumberexpressions.Group) *PatternMatchGroup {
+	result := &PatternMatchGroup{
+		Value: group.Value(),
+		Start: group.Start(),
+		End:   group.End(),
+	}
+	for _, child := range group.Children() {
+		result.Children = append(result.Children, NewPatternMatchGroup(child))
+	}
+	return result
+}
@@ -5,20 +5,53 @@ import messages "github.com/cucumber/cucumber-messages-go/v3"
 // TestRunResult is the result of a test run
 type TestRunResult struct {
 	Success bool `json:"success"`
+	// TestCaseCounts is the number of test cases per status name
+	TestCaseCounts map[string]int `json:"testCaseCounts"`
+	// TestStepCounts is the number of steps per status name, hooks are not included
+	TestStepCounts                 map[string]int `json:"testStepCounts"`
+	DurationNanoseconds            uint64         `json:"durationNanoseconds"`
+	MinTestCaseDurationNanoseconds uint64         `json:"minTestCaseDurationNanoseconds"`
+	MaxTestCaseDurationNanoseconds uint64         `json:"maxTestCaseDurationNanoseconds"`
+	FailingPickleIds               []string       `json:"failingPickleIds"`
 }
 
 // NewTestRunResult creates a new test run result
 func NewTestRunResult() *TestRunResult {
 	return &TestRunResult{
-		Success: true,
+		Success:          true,
+		TestCaseCounts:   map[string]int{},
+		TestStepCounts:   map[string]int{},
+		FailingPickleIds: []string{},
 	}
 }
 
-// Update updates the test run result with a test case result
-func (t *TestRunResult) Update(testCaseResult *messages.TestResult, isStrict bool) {
+// Update updates the test run result with the result of a test case and its steps
+func (t *TestRunResult) Update(pickleID string, testCaseResult *messages.TestResult, testStepResults []*messages.TestResult, isStrict bool) {
 	if shouldCauseFailure(testCaseResult.Status, isStrict) {
 		t.Success = false
+		t.FailingPickleIds = append(t.FailingPickleIds, pickleID)
 	}
+	isFirstTestCase := t.getTestCaseCount() == 0
+	t.TestCaseCounts[testCaseResult.Status.String()]++
+	for _, testStepResult := range testStepResults {
+		t.TestStepCounts[testStepResult.Status.String()]++
+	}
+	duration := testCaseResult.DurationNanoseconds
+	t.DurationNanoseconds += duration
+	if isFirstTestCase || duration < t.MinTestCaseDurationNanoseconds {
+		t.MinTestCaseDurationNanoseconds = duration
+	}
+	if duration > t.MaxTestCaseDurationNanoseconds {
+		t.MaxTestCaseDurationNanoseconds = duration
+	}
+}
+
+func (t *TestRunResult) getTestCaseCount() int {
+	count := 0
+	for _, statusCount := range t.TestCaseCounts {
+		count += statusCount
+	}
+	return count
 }
 
 func shouldCauseFailure(status messages.TestResult_Status, isStrict bool) bool {
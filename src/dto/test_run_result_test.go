@@ -0,0 +1,91 @@
+package dto_test
+
+import (
+	"github.com/cucumber/cucumber-engine/src/dto"
+	messages "github.com/cucumber/cucumber-messages-go/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TestRunResult", func() {
+	Describe("Update", func() {
+		Context("with no test cases", func() {
+			It("is successful", func() {
+				testRunResult := dto.NewTestRunResult()
+				Expect(testRunResult.Success).To(BeTrue())
+				Expect(testRunResult.FailingPickleIds).To(BeEmpty())
+			})
+		})
+
+		Context("with passing, failing and pending test cases", func() {
+			var testRunResult *dto.TestRunResult
+
+			BeforeEach(func() {
+				testRunResult = dto.NewTestRunResult()
+				testRunResult.Update(
+					"pickle1",
+					&messages.TestResult{Status: messages.TestResult_PASSED, DurationNanoseconds: 5},
+					[]*messages.TestResult{
+						{Status: messages.TestResult_PASSED, DurationNanoseconds: 2},
+						{Status: messages.TestResult_PASSED, DurationNanoseconds: 3},
+					},
+					false,
+				)
+				testRunResult.Update(
+					"pickle2",
+					&messages.TestResult{Status: messages.TestResult_FAILED, DurationNanoseconds: 3},
+					[]*messages.TestResult{
+						{Status: messages.TestResult_FAILED, DurationNanoseconds: 3},
+						{Status: messages.TestResult_SKIPPED},
+					},
+					false,
+				)
+				testRunResult.Update(
+					"pickle3",
+					&messages.TestResult{Status: messages.TestResult_PENDING, DurationNanoseconds: 10},
+					[]*messages.TestResult{
+						{Status: messages.TestResult_PENDING, DurationNanoseconds: 10},
+					},
+					false,
+				)
+			})
+
+			It("is not successful", func() {
+				Expect(testRunResult.Success).To(BeFalse())
+			})
+
+			It("counts the test cases and steps per status", func() {
+				Expect(testRunResult.TestCaseCounts).To(Equal(map[string]int{
+					"PASSED":  1,
+					"FAILED":  1,
+					"PENDING": 1,
+				}))
+				Expect(testRunResult.TestStepCounts).To(Equal(map[string]int{
+					"PASSED":  2,
+					"FAILED":  1,
+					"SKIPPED": 1,
+					"PENDING": 1,
+				}))
+			})
+
+			It("tracks the total, min and max durations", func() {
+				Expect(testRunResult.DurationNanoseconds).To(Equal(uint64(18)))
+				Expect(testRunResult.MinTestCaseDurationNanoseconds).To(Equal(uint64(3)))
+				Expect(testRunResult.MaxTestCaseDurationNanoseconds).To(Equal(uint64(10)))
+			})
+
+			It("lists the failing pickle ids", func() {
+				Expect(testRunResult.FailingPickleIds).To(Equal([]string{"pickle2"}))
+			})
+		})
+
+		Context("with a pending test case in strict mode", func() {
+			It("is not successful", func() {
+				testRunResult := dto.NewTestRunResult()
+				testRunResult.Update("pickle1", &messages.TestResult{Status: messages.TestResult_PENDING}, nil, true)
+				Expect(testRunResult.Success).To(BeFalse())
+				Expect(testRunResult.FailingPickleIds).To(Equal([]string{"pickle1"}))
+			})
+		})
+	})
+})
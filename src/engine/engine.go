@@ -0,0 +1,253 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/cucumber/cucumber-engine/src/dto"
+	"github.com/cucumber/cucumber-engine/src/dto/event"
+	"github.com/cucumber/cucumber-engine/src/runner"
+	messages "github.com/cucumber/cucumber-messages-go/v3"
+	"github.com/gogo/protobuf/proto"
+)
+
+// HookType is the type of hook to run
+type HookType int
+
+// The types of hooks
+const (
+	BeforeTestRunHooks HookType = iota
+	AfterTestRunHooks
+	BeforeTestCaseHook
+	AfterTestCaseHook
+)
+
+// Hook identifies the hook to run. The pickle and the test case hook
+// definition id are only set for test case hooks
+type Hook struct {
+	Type                     HookType
+	PickleID                 string
+	TestCaseHookDefinitionID string
+}
+
+// Step identifies the step definition to run and its arguments
+type Step struct {
+	PickleID           string
+	StepDefinitionID   string
+	PatternMatches     []*messages.PatternMatch
+	PickleStepArgument *messages.PickleStepArgument
+}
+
+// SupportCode runs the hooks and steps of a test run. Its methods are called
+// concurrently when running test cases in parallel
+type SupportCode interface {
+	InitializeTestCase(ctx context.Context, pickle *messages.Pickle)
+	RunHook(ctx context.Context, hook *Hook) *messages.TestResult
+	RunStep(ctx context.Context, step *Step) *messages.TestResult
+	GenerateSnippet(ctx context.Context, generatedExpressions []*messages.GeneratedExpression, pickleStepArgument *messages.PickleStepArgument) string
+}
+
+// Config is the configuration for Run
+type Config struct {
+	BaseDirectory     string
+	SourcesConfig     *messages.SourcesConfig
+	RuntimeConfig     *messages.RuntimeConfig
+	SupportCodeConfig *messages.SupportCodeConfig
+	EngineConfig      *dto.EngineConfig
+	SupportCode       SupportCode
+	// OnEvent is called with every event of the test run, optional
+	OnEvent func(*messages.Envelope)
+}
+
+// Run executes a test run in process. Cancelling the context cancels the run.
+// The result of the run is returned once it has finished
+func Run(ctx context.Context, config *Config) (*dto.TestRunResult, error) {
+	engineConfig := config.EngineConfig
+	if engineConfig == nil {
+		engineConfig = &dto.EngineConfig{}
+	}
+	r := runner.NewRunner(engineConfig)
+	incoming, outgoing := r.GetCommandChannels()
+	s := &sender{incoming: incoming, finished: make(chan struct{})}
+	defer s.close()
+	go s.send(&messages.Envelope{
+		Message: &messages.Envelope_CommandStart{
+			CommandStart: getCommandStart(config),
+		},
+	})
+	var testRunResult *dto.TestRunResult
+	var err error
+	done := ctx.Done()
+	for {
+		select {
+		case <-done:
+			r.Cancel()
+			done = nil
+		case command, ok := <-outgoing:
+			if !ok {
+				if err == nil && testRunResult == nil {
+					err = errors.New("test run ended without a result")
+				}
+				return testRunResult, err
+			}
+			switch x := command.Message.(type) {
+			case *messages.Envelope_CommandError:
+				if err == nil {
+					err = errors.New(x.CommandError)
+				}
+			case *messages.Envelope_Attachment:
+				if x.Attachment.GetMedia().GetContentType() == event.TestRunSummaryContentType {
+					testRunResult = &dto.TestRunResult{}
+					if unmarshalErr := json.Unmarshal([]byte(x.Attachment.Data), testRunResult); unmarshalErr != nil && err == nil {
+						err = unmarshalErr
+					}
+				}
+				if config.OnEvent != nil {
+					config.OnEvent(command)
+				}
+			default:
+				if !respond(ctx, config.SupportCode, s, command) && config.OnEvent != nil {
+					config.OnEvent(command)
+				}
+			}
+		}
+	}
+}
+
+// getCommandStart returns the start command for the config, defaulting the
+// configs left nil. The configs of the caller are not modified
+func getCommandStart(config *Config) *messages.CommandStart {
+	sourcesConfig := &messages.SourcesConfig{}
+	if config.SourcesConfig != nil {
+		sourcesConfig = proto.Clone(config.SourcesConfig).(*messages.SourcesConfig)
+	}
+	if sourcesConfig.Filters == nil {
+		sourcesConfig.Filters = &messages.SourcesFilterConfig{}
+	}
+	if sourcesConfig.Order == nil {
+		sourcesConfig.Order = &messages.SourcesOrder{}
+	}
+	runtimeConfig := config.RuntimeConfig
+	if runtimeConfig == nil {
+		runtimeConfig = &messages.RuntimeConfig{}
+	}
+	supportCodeConfig := config.SupportCodeConfig
+	if supportCodeConfig == nil {
+		supportCodeConfig = &messages.SupportCodeConfig{}
+	}
+	return &messages.CommandStart{
+		BaseDirectory:     config.BaseDirectory,
+		SourcesConfig:     sourcesConfig,
+		RuntimeConfig:     runtimeConfig,
+		SupportCodeConfig: supportCodeConfig,
+	}
+}
+
+// sender sends commands to the runner until the run has finished. Run does not wait
+// for the actions that timed out, their responses are dropped once the run has finished
+type sender struct {
+	mutex    sync.RWMutex
+	incoming chan *messages.Envelope
+	finished chan struct{}
+	closed   bool
+}
+
+func (s *sender) send(command *messages.Envelope) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.incoming <- command:
+	case <-s.finished:
+	}
+}
+
+// close unblocks the pending sends and stops the runner
+func (s *sender) close() {
+	close(s.finished)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	close(s.incoming)
+}
+
+// respond runs the action for the command, if it is one, in the background and
+// sends the response. Returns whether the command was an action
+func respond(ctx context.Context, supportCode SupportCode, s *sender, command *messages.Envelope) bool {
+	var actionID string
+	var action func() *messages.CommandActionComplete
+	switch x := command.Message.(type) {
+	case *messages.Envelope_CommandRunBeforeTestRunHooks:
+		actionID = x.CommandRunBeforeTestRunHooks.ActionId
+		action = runHookAction(ctx, supportCode, &Hook{Type: BeforeTestRunHooks})
+	case *messages.Envelope_CommandRunAfterTestRunHooks:
+		actionID = x.CommandRunAfterTestRunHooks.ActionId
+		action = runHookAction(ctx, supportCode, &Hook{Type: AfterTestRunHooks})
+	case *messages.Envelope_CommandInitializeTestCase:
+		actionID = x.CommandInitializeTestCase.ActionId
+		action = func() *messages.CommandActionComplete {
+			supportCode.InitializeTestCase(ctx, x.CommandInitializeTestCase.Pickle)
+			return &messages.CommandActionComplete{}
+		}
+	case *messages.Envelope_CommandRunBeforeTestCaseHook:
+		actionID = x.CommandRunBeforeTestCaseHook.ActionId
+		action = runHookAction(ctx, supportCode, &Hook{
+			Type:                     BeforeTestCaseHook,
+			PickleID:                 x.CommandRunBeforeTestCaseHook.PickleId,
+			TestCaseHookDefinitionID: x.CommandRunBeforeTestCaseHook.TestCaseHookDefinitionId,
+		})
+	case *messages.Envelope_CommandRunAfterTestCaseHook:
+		actionID = x.CommandRunAfterTestCaseHook.ActionId
+		action = runHookAction(ctx, supportCode, &Hook{
+			Type:                     AfterTestCaseHook,
+			PickleID:                 x.CommandRunAfterTestCaseHook.PickleId,
+			TestCaseHookDefinitionID: x.CommandRunAfterTestCaseHook.TestCaseHookDefinitionId,
+		})
+	case *messages.Envelope_CommandRunTestStep:
+		actionID = x.CommandRunTestStep.ActionId
+		action = func() *messages.CommandActionComplete {
+			testResult := supportCode.RunStep(ctx, &Step{
+				PickleID:           x.CommandRunTestStep.PickleId,
+				StepDefinitionID:   x.CommandRunTestStep.StepDefinitionId,
+				PatternMatches:     x.CommandRunTestStep.PatternMatches,
+				PickleStepArgument: x.CommandRunTestStep.PickleStepArgument,
+			})
+			return &messages.CommandActionComplete{
+				Result: &messages.CommandActionComplete_TestResult{TestResult: testResult},
+			}
+		}
+	case *messages.Envelope_CommandGenerateSnippet:
+		actionID = x.CommandGenerateSnippet.ActionId
+		action = func() *messages.CommandActionComplete {
+			snippet := supportCode.GenerateSnippet(ctx, x.CommandGenerateSnippet.GeneratedExpressions, x.CommandGenerateSnippet.PickleStepArgument)
+			return &messages.CommandActionComplete{
+				Result: &messages.CommandActionComplete_Snippet{Snippet: snippet},
+			}
+		}
+	default:
+		return false
+	}
+	go func() {
+		response := action()
+		response.CompletedId = actionID
+		s.send(&messages.Envelope{
+			Message: &messages.Envelope_CommandActionComplete{
+				CommandActionComplete: response,
+			},
+		})
+	}()
+	return true
+}
+
+func runHookAction(ctx context.Context, supportCode SupportCode, hook *Hook) func() *messages.CommandActionComplete {
+	return func() *messages.CommandActionComplete {
+		testResult := supportCode.RunHook(ctx, hook)
+		return &messages.CommandActionComplete{
+			Result: &messages.CommandActionComplete_TestResult{TestResult: testResult},
+		}
+	}
+}
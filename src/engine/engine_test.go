@@ -0,0 +1,238 @@
+package engine_test
+
+import (
+	"context"
+	"path"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/cucumber/cucumber-engine/src/dto"
+	"github.com/cucumber/cucumber-engine/src/engine"
+	messages "github.com/cucumber/cucumber-messages-go/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeSupportCode struct {
+	mutex            sync.Mutex
+	initializedNames []string
+	hookTypes        []engine.HookType
+	steps            []*engine.Step
+}
+
+func (f *fakeSupportCode) InitializeTestCase(ctx context.Context, pickle *messages.Pickle) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.initializedNames = append(f.initializedNames, pickle.Name)
+}
+
+func (f *fakeSupportCode) RunHook(ctx context.Context, hook *engine.Hook) *messages.TestResult {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.hookTypes = append(f.hookTypes, hook.Type)
+	return &messages.TestResult{Status: messages.TestResult_PASSED}
+}
+
+func (f *fakeSupportCode) RunStep(ctx context.Context, step *engine.Step) *messages.TestResult {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.steps = append(f.steps, step)
+	return &messages.TestResult{Status: messages.TestResult_PASSED, DurationNanoseconds: 1}
+}
+
+func (f *fakeSupportCode) GenerateSnippet(ctx context.Context, generatedExpressions []*messages.GeneratedExpression, pickleStepArgument *messages.PickleStepArgument) string {
+	return "snippet for " + generatedExpressions[0].Text
+}
+
+var _ = Describe("Run", func() {
+	_, filename, _, _ := runtime.Caller(0)
+	rootDir := path.Join(filename, "..", "..", "..")
+	featurePath := path.Join(rootDir, "test", "fixtures", "tags.feature")
+
+	var supportCode *fakeSupportCode
+	var testRunResult *dto.TestRunResult
+	var events []*messages.Envelope
+	var err error
+
+	BeforeEach(func() {
+		supportCode = &fakeSupportCode{}
+		events = []*messages.Envelope{}
+		testRunResult, err = engine.Run(context.Background(), &engine.Config{
+			SourcesConfig: &messages.SourcesConfig{
+				AbsolutePaths: []string{featurePath},
+				Filters:       &messages.SourcesFilterConfig{},
+				Language:      "en",
+				Order:         &messages.SourcesOrder{},
+			},
+			RuntimeConfig: &messages.RuntimeConfig{MaxParallel: 1},
+			SupportCodeConfig: &messages.SupportCodeConfig{
+				BeforeTestCaseHookDefinitionConfigs: []*messages.TestCaseHookDefinitionConfig{
+					{Id: "hook1", TagExpression: "@tagA"},
+				},
+				StepDefinitionConfigs: []*messages.StepDefinitionConfig{
+					{
+						Id: "step1",
+						Pattern: &messages.StepDefinitionPattern{
+							Source: "an expection",
+							Type:   messages.StepDefinitionPatternType_CUCUMBER_EXPRESSION,
+						},
+					},
+				},
+			},
+			SupportCode: supportCode,
+			OnEvent: func(e *messages.Envelope) {
+				events = append(events, e)
+			},
+		})
+	})
+
+	It("calls the support code", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(supportCode.initializedNames).To(Equal([]string{"A1", "A2"}))
+		Expect(supportCode.hookTypes).To(Equal([]engine.HookType{
+			engine.BeforeTestRunHooks,
+			engine.BeforeTestCaseHook,
+			engine.AfterTestRunHooks,
+		}))
+		Expect(supportCode.steps).To(HaveLen(1))
+		Expect(supportCode.steps[0].StepDefinitionID).To(Equal("step1"))
+	})
+
+	It("returns the result of the test run", func() {
+		Expect(testRunResult.Success).To(BeFalse())
+		Expect(testRunResult.TestCaseCounts).To(Equal(map[string]int{"PASSED": 1, "UNDEFINED": 1}))
+	})
+
+	It("passes the events to OnEvent", func() {
+		Expect(events[len(events)-1].GetTestRunFinished()).To(Equal(&messages.TestRunFinished{Success: false}))
+		for _, e := range events {
+			if testStepFinished := e.GetTestStepFinished(); testStepFinished != nil && testStepFinished.TestResult.Status == messages.TestResult_UNDEFINED {
+				Expect(testStepFinished.TestResult.Message).To(Equal("snippet for another expection"))
+			}
+		}
+	})
+})
+
+type blockingSupportCode struct {
+	fakeSupportCode
+	unblock chan struct{}
+}
+
+func (b *blockingSupportCode) RunStep(ctx context.Context, step *engine.Step) *messages.TestResult {
+	<-b.unblock
+	return &messages.TestResult{Status: messages.TestResult_PASSED}
+}
+
+var _ = Describe("Run with a step that never returns", func() {
+	_, filename, _, _ := runtime.Caller(0)
+	featurePath := path.Join(filename, "..", "..", "..", "test", "fixtures", "a.feature")
+
+	It("returns once the step times out", func() {
+		supportCode := &blockingSupportCode{unblock: make(chan struct{})}
+		defer close(supportCode.unblock)
+		result := make(chan *dto.TestRunResult)
+		go func() {
+			defer GinkgoRecover()
+			testRunResult, err := engine.Run(context.Background(), &engine.Config{
+				SourcesConfig: &messages.SourcesConfig{
+					AbsolutePaths: []string{featurePath},
+					Filters:       &messages.SourcesFilterConfig{},
+					Language:      "en",
+					Order:         &messages.SourcesOrder{},
+				},
+				RuntimeConfig: &messages.RuntimeConfig{MaxParallel: 1},
+				SupportCodeConfig: &messages.SupportCodeConfig{
+					StepDefinitionConfigs: []*messages.StepDefinitionConfig{
+						{
+							Id: "step1",
+							Pattern: &messages.StepDefinitionPattern{
+								Source: "a precondition",
+								Type:   messages.StepDefinitionPatternType_CUCUMBER_EXPRESSION,
+							},
+						},
+					},
+				},
+				EngineConfig: &dto.EngineConfig{TestStepTimeout: 10 * time.Millisecond},
+				SupportCode:  supportCode,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			result <- testRunResult
+		}()
+		var testRunResult *dto.TestRunResult
+		Eventually(result, time.Second).Should(Receive(&testRunResult))
+		Expect(testRunResult.Success).To(BeFalse())
+	})
+})
+
+var _ = Describe("Run with only the required config", func() {
+	_, filename, _, _ := runtime.Caller(0)
+	featurePath := path.Join(filename, "..", "..", "..", "test", "fixtures", "a.feature")
+
+	It("defaults the other configs", func() {
+		testRunResult, err := engine.Run(context.Background(), &engine.Config{
+			SourcesConfig: &messages.SourcesConfig{
+				AbsolutePaths: []string{featurePath},
+				Language:      "en",
+			},
+			SupportCode: &fakeSupportCode{},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(testRunResult.TestCaseCounts).To(Equal(map[string]int{"UNDEFINED": 1}))
+	})
+})
+
+var _ = Describe("Run with an invalid config", func() {
+	_, filename, _, _ := runtime.Caller(0)
+	featurePath := path.Join(filename, "..", "..", "..", "test", "fixtures", "a.feature")
+
+	It("returns the error", func() {
+		_, err := engine.Run(context.Background(), &engine.Config{
+			SourcesConfig: &messages.SourcesConfig{
+				AbsolutePaths: []string{featurePath},
+				Filters:       &messages.SourcesFilterConfig{},
+				Language:      "en",
+				Order:         &messages.SourcesOrder{},
+			},
+			RuntimeConfig: &messages.RuntimeConfig{},
+			SupportCodeConfig: &messages.SupportCodeConfig{
+				StepDefinitionConfigs: []*messages.StepDefinitionConfig{
+					{
+						Id:      "step1",
+						Pattern: &messages.StepDefinitionPattern{Source: "a step", Type: 3},
+					},
+				},
+			},
+			SupportCode: &fakeSupportCode{},
+		})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Run repeatedly", func() {
+	_, filename, _, _ := runtime.Caller(0)
+	featurePath := path.Join(filename, "..", "..", "..", "test", "fixtures", "a.feature")
+
+	It("does not leak goroutines", func() {
+		run := func() {
+			_, err := engine.Run(context.Background(), &engine.Config{
+				SourcesConfig: &messages.SourcesConfig{
+					AbsolutePaths: []string{featurePath},
+					Filters:       &messages.SourcesFilterConfig{},
+					Language:      "en",
+					Order:         &messages.SourcesOrder{},
+				},
+				RuntimeConfig:     &messages.RuntimeConfig{MaxParallel: 1},
+				SupportCodeConfig: &messages.SupportCodeConfig{},
+				SupportCode:       &fakeSupportCode{},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		run()
+		before := runtime.NumGoroutine()
+		for i := 0; i < 10; i++ {
+			run()
+		}
+		Eventually(runtime.NumGoroutine, time.Second).Should(BeNumerically("<=", before))
+	})
+})
@@ -0,0 +1,52 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	messages "github.com/cucumber/cucumber-messages-go/v3"
+	tagexpressions "github.com/cucumber/tag-expressions-go"
+)
+
+// exclusiveGroups determines the mutual exclusion groups of a pickle.
+// Pickles that share a group are never run at the same time
+type exclusiveGroups struct {
+	tagPrefixes    []string
+	tagExpressions []tagexpressions.Evaluatable
+}
+
+func newExclusiveGroups(tagPrefixes []string, tagExpressionSources []string) (*exclusiveGroups, error) {
+	tagExpressions := make([]tagexpressions.Evaluatable, len(tagExpressionSources))
+	for i, source := range tagExpressionSources {
+		var err error
+		tagExpressions[i], err = tagexpressions.Parse(source)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &exclusiveGroups{
+		tagPrefixes:    tagPrefixes,
+		tagExpressions: tagExpressions,
+	}, nil
+}
+
+// get returns the groups of the pickle: each tag that starts with one of the
+// prefixes and each tag expression the pickle matches
+func (e *exclusiveGroups) get(pickle *messages.Pickle) []string {
+	var result []string
+	tagNames := getPickleTagNames(pickle)
+	for _, tagName := range tagNames {
+		for _, tagPrefix := range e.tagPrefixes {
+			if strings.HasPrefix(tagName, tagPrefix) {
+				result = append(result, "tag:"+tagName)
+				break
+			}
+		}
+	}
+	for i, tagExpression := range e.tagExpressions {
+		if tagExpression.Evaluate(tagNames) {
+			result = append(result, fmt.Sprintf("expression:%d", i))
+		}
+	}
+	return result
+}
@@ -4,11 +4,134 @@ import (
 	"bytes"
 	"fmt"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/cucumber/cucumber-engine/src/dto"
+	"github.com/cucumber/cucumber-engine/src/dto/event"
+	messages "github.com/cucumber/cucumber-messages-go/v3"
+	tagexpressions "github.com/cucumber/tag-expressions-go"
 	"github.com/olekukonko/tablewriter"
+	uuid "github.com/satori/go.uuid"
 )
 
+func getPickleTagNames(pickle *messages.Pickle) []string {
+	tagNames := make([]string, len(pickle.Tags))
+	for i, tag := range pickle.Tags {
+		tagNames[i] = tag.Name
+	}
+	return tagNames
+}
+
+// getRelativeURI returns the uri relative to the base directory. The uri is
+// returned as is when there is no base directory or no uri
+func getRelativeURI(baseDirectory, uri string) (string, error) {
+	if baseDirectory == "" || uri == "" {
+		return uri, nil
+	}
+	return filepath.Rel(baseDirectory, uri)
+}
+
+// getStepDefinitionReference returns the reference to the step definition used in
+// attachments, with its uri relative to the base directory
+func getStepDefinitionReference(baseDirectory string, stepDefinition *dto.StepDefinition) (*event.StepDefinitionReference, error) {
+	uri, err := getRelativeURI(baseDirectory, stepDefinition.Config.GetLocation().GetUri())
+	if err != nil {
+		return nil, err
+	}
+	return event.NewStepDefinitionReference(stepDefinition, uri), nil
+}
+
+// getPortablePickleURI returns the uri of the pickle relative to the base directory with
+// forward slashes, so the shards, timings and stable ids are the same on every platform
+func getPortablePickleURI(baseDirectory string, pickle *messages.Pickle) (string, error) {
+	uri, err := getRelativeURI(baseDirectory, pickle.Uri)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(uri), nil
+}
+
+// getPickleLocation returns `uri:line` for the pickle, with the portable uri of the pickle
+func getPickleLocation(baseDirectory string, pickle *messages.Pickle) (string, error) {
+	uri, err := getPortablePickleURI(baseDirectory, pickle)
+	if err != nil {
+		return "", err
+	}
+	line := pickle.Locations[len(pickle.Locations)-1].Line
+	return uri + ":" + strconv.Itoa(int(line)), nil
+}
+
+// stablePickleIDNamespace is the namespace of the name based uuids of pickles
+var stablePickleIDNamespace = uuid.NewV5(uuid.NamespaceURL, "https://github.com/cucumber/cucumber-engine/pickle")
+
+// getStablePickleID returns an id derived from the uri relative to the base directory
+// and all location lines of the pickle, which include the example row
+func getStablePickleID(baseDirectory string, pickle *messages.Pickle) (string, error) {
+	name, err := getPortablePickleURI(baseDirectory, pickle)
+	if err != nil {
+		return "", err
+	}
+	for _, location := range pickle.Locations {
+		name += ":" + strconv.Itoa(int(location.Line))
+	}
+	return uuid.NewV5(stablePickleIDNamespace, name).String(), nil
+}
+
+// getStepDefinitionWarningDescription returns the message of the warning
+// followed by the locations of the step definitions
+func getStepDefinitionWarningDescription(warning *event.StepDefinitionWarning) string {
+	locations := []string{}
+	for _, stepDefinition := range warning.StepDefinitions {
+		if stepDefinition.URI != "" && stepDefinition.Line != 0 {
+			locations = append(locations, fmt.Sprintf("%s:%d", stepDefinition.URI, stepDefinition.Line))
+		}
+	}
+	if len(locations) == 0 {
+		return warning.Message
+	}
+	return fmt.Sprintf("%s (%s)", warning.Message, strings.Join(locations, ", "))
+}
+
+func getSupportCodeErrorDescription(configError *event.SupportCodeError) string {
+	var description string
+	switch configError.Kind {
+	case parameterTypeError:
+		description = fmt.Sprintf("parameter type '%s'", configError.ParameterTypeName)
+	case beforeTestCaseHookDefinitionError:
+		description = fmt.Sprintf("before test case hook with tag expression '%s'", configError.TagExpression)
+	case afterTestCaseHookDefinitionError:
+		description = fmt.Sprintf("after test case hook with tag expression '%s'", configError.TagExpression)
+	default:
+		description = fmt.Sprintf("step definition '%s'", configError.PatternSource)
+	}
+	if configError.URI != "" && configError.Line != 0 {
+		description = fmt.Sprintf("%s (%s:%d)", description, configError.URI, configError.Line)
+	}
+	return fmt.Sprintf("%s: %s", description, configError.Message)
+}
+
+func getPicklesWithIds(pickles []*messages.Pickle, pickleIds []string) []*messages.Pickle {
+	isIncluded := map[string]bool{}
+	for _, pickleID := range pickleIds {
+		isIncluded[pickleID] = true
+	}
+	result := []*messages.Pickle{}
+	for _, pickle := range pickles {
+		if isIncluded[pickle.Id] {
+			result = append(result, pickle)
+		}
+	}
+	return result
+}
+
+func getRetryCount(pickle *messages.Pickle, retryCount int, retryTagExpression tagexpressions.Evaluatable) int {
+	if retryCount == 0 || !retryTagExpression.Evaluate(getPickleTagNames(pickle)) {
+		return 0
+	}
+	return retryCount
+}
+
 func getAmbiguousStepDefinitionsMessage(stepDefinitions []*dto.StepDefinition, baseDirectory string) (string, error) {
 	buf := bytes.Buffer{}
 	table := tablewriter.NewWriter(&buf)
@@ -21,13 +144,9 @@ func getAmbiguousStepDefinitionsMessage(stepDefinitions []*dto.StepDefinition, b
 		location := ""
 		sourceReference := stepDefinition.Config.GetLocation()
 		if (sourceReference.GetLocation().GetLine() != 0) && sourceReference.GetUri() != "" {
-			uri := sourceReference.GetUri()
-			if baseDirectory != "" {
-				var err error
-				uri, err = filepath.Rel(baseDirectory, uri)
-				if err != nil {
-					return "", err
-				}
+			uri, err := getRelativeURI(baseDirectory, sourceReference.GetUri())
+			if err != nil {
+				return "", err
 			}
 			location = fmt.Sprintf("%s:%d", uri, sourceReference.GetLocation().GetLine())
 		}
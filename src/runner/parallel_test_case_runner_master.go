@@ -3,81 +3,185 @@ package runner
 import (
 	dto "github.com/cucumber/cucumber-engine/src/dto"
 	messages "github.com/cucumber/cucumber-messages-go/v3"
+	tagexpressions "github.com/cucumber/tag-expressions-go"
 )
 
 type runNextTestCaseResult struct {
-	err            error
-	testCaseResult *messages.TestResult
+	err             error
+	pickleID        string
+	testCaseResult  *messages.TestResult
+	testStepResults []*messages.TestResult
 }
 
 type parallelTestCaseRunnerMaster struct {
 	baseDirectory               string
-	nextPickleIndex             int
-	pickles                     []*messages.Pickle
+	exclusiveGroups             *exclusiveGroups
+	isCancelled                 func() bool
+	pickleIDToExclusiveGroups   map[string][]string
+	remainingPickles            []*messages.Pickle
+	retryCount                  int
+	retryTagExpression          tagexpressions.Evaluatable
+	runningExclusiveGroups      map[string]bool
 	runtimeConfig               *messages.RuntimeConfig
+	serialTagExpression         tagexpressions.Evaluatable
+	serialTestCaseRunning       bool
+	stepDefinitionUsage         *stepDefinitionUsageRecorder
 	sendCommand                 func(*messages.Envelope)
 	sendCommandAndAwaitResponse func(*messages.Envelope) *messages.Envelope
 	supportCodeLibrary          *SupportCodeLibrary
+	testCaseDurations           testCaseDurations
+	transformParameters         bool
 }
 
 func newParallelTestCaseRunnerMaster(opts *runTestCasesOptions) *parallelTestCaseRunnerMaster {
 	return &parallelTestCaseRunnerMaster{
 		baseDirectory:               opts.baseDirectory,
-		nextPickleIndex:             0,
-		pickles:                     opts.pickles,
+		exclusiveGroups:             opts.exclusiveGroups,
+		isCancelled:                 opts.isCancelled,
+		pickleIDToExclusiveGroups:   map[string][]string{},
+		remainingPickles:            append([]*messages.Pickle{}, opts.pickles...),
+		retryCount:                  opts.retryCount,
+		retryTagExpression:          opts.retryTagExpression,
+		runningExclusiveGroups:      map[string]bool{},
 		runtimeConfig:               opts.runtimeConfig,
+		serialTagExpression:         opts.serialTagExpression,
+		stepDefinitionUsage:         opts.stepDefinitionUsage,
 		sendCommand:                 opts.sendCommand,
 		sendCommandAndAwaitResponse: opts.sendCommandAndAwaitResponse,
 		supportCodeLibrary:          opts.supportCodeLibrary,
+		testCaseDurations:           opts.testCaseDurations,
+		transformParameters:         opts.transformParameters,
 	}
 }
 
-func (p *parallelTestCaseRunnerMaster) run() (bool, error) {
+func (p *parallelTestCaseRunnerMaster) run() (*dto.TestRunResult, error) {
 	testRunResult := dto.NewTestRunResult()
 	isSkipped := p.runtimeConfig.IsDryRun
-	numRunning := 0
-	toStart := int(p.runtimeConfig.MaxParallel)
-	if toStart == 0 || toStart > len(p.pickles) {
-		toStart = len(p.pickles)
-	}
-	onFinish := make(chan *runNextTestCaseResult, toStart)
-	for i := 1; i <= toStart; i++ {
-		p.runNextTestCase(isSkipped, onFinish)
-		numRunning++
+	maxRunning := int(p.runtimeConfig.MaxParallel)
+	if maxRunning == 0 || maxRunning > len(p.remainingPickles) {
+		maxRunning = len(p.remainingPickles)
 	}
+	onFinish := make(chan *runNextTestCaseResult, maxRunning)
+	numRunning := p.startRunnableTestCases(0, maxRunning, isSkipped, onFinish)
+	var err error
 	for numRunning > 0 {
 		result := <-onFinish
+		numRunning--
 		if result.err != nil {
-			return false, result.err
+			// the running test cases still send commands, wait for them before returning
+			if err == nil {
+				err = result.err
+			}
+			continue
 		}
-		testRunResult.Update(result.testCaseResult, p.runtimeConfig.IsStrict)
+		p.releaseExclusiveGroups(result.pickleID)
+		p.serialTestCaseRunning = false
+		p.testCaseDurations.record(result.pickleID, result.testCaseResult)
+		testRunResult.Update(result.pickleID, result.testCaseResult, result.testStepResults, p.runtimeConfig.IsStrict)
 		if !isSkipped && !testRunResult.Success && p.runtimeConfig.IsFailFast {
 			isSkipped = true
 		}
-		if p.nextPickleIndex == len(p.pickles) {
-			numRunning--
-		} else {
-			p.runNextTestCase(isSkipped, onFinish)
+		if err == nil && !p.isCancelled() {
+			numRunning = p.startRunnableTestCases(numRunning, maxRunning, isSkipped, onFinish)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return testRunResult, nil
+}
+
+// startRunnableTestCases starts test cases until maxRunning are running or no
+// remaining test case can run alongside the running ones. Returns the number running
+func (p *parallelTestCaseRunnerMaster) startRunnableTestCases(numRunning, maxRunning int, isSkipped bool, onFinish chan *runNextTestCaseResult) int {
+	for numRunning < maxRunning {
+		index := p.getNextRunnablePickleIndex(numRunning)
+		if index == -1 {
+			break
+		}
+		pickle := p.remainingPickles[index]
+		p.remainingPickles = append(p.remainingPickles[:index], p.remainingPickles[index+1:]...)
+		p.acquireExclusiveGroups(pickle)
+		p.serialTestCaseRunning = p.isSerial(pickle)
+		p.runNextTestCase(pickle, isSkipped, onFinish)
+		numRunning++
+	}
+	return numRunning
+}
+
+// getNextRunnablePickleIndex returns the index of the first remaining pickle that
+// does not share an exclusive group with a running one, or -1 if there is none.
+// A serial pickle is only runnable once nothing else is running and
+// no pickle after it is started until then
+func (p *parallelTestCaseRunnerMaster) getNextRunnablePickleIndex(numRunning int) int {
+	if p.serialTestCaseRunning {
+		return -1
+	}
+	for index, pickle := range p.remainingPickles {
+		if p.isSerial(pickle) {
+			if numRunning == 0 {
+				return index
+			}
+			return -1
+		}
+		if !p.hasRunningExclusiveGroup(pickle) {
+			return index
 		}
 	}
-	return testRunResult.Success, nil
+	return -1
 }
 
-func (p *parallelTestCaseRunnerMaster) runNextTestCase(isSkipped bool, onFinish chan *runNextTestCaseResult) {
-	pickle := p.pickles[p.nextPickleIndex]
-	p.nextPickleIndex++
+func (p *parallelTestCaseRunnerMaster) isSerial(pickle *messages.Pickle) bool {
+	return p.serialTagExpression != nil && p.serialTagExpression.Evaluate(getPickleTagNames(pickle))
+}
+
+func (p *parallelTestCaseRunnerMaster) hasRunningExclusiveGroup(pickle *messages.Pickle) bool {
+	for _, group := range p.exclusiveGroups.get(pickle) {
+		if p.runningExclusiveGroups[group] {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parallelTestCaseRunnerMaster) acquireExclusiveGroups(pickle *messages.Pickle) {
+	groups := p.exclusiveGroups.get(pickle)
+	for _, group := range groups {
+		p.runningExclusiveGroups[group] = true
+	}
+	p.pickleIDToExclusiveGroups[pickle.Id] = groups
+}
+
+func (p *parallelTestCaseRunnerMaster) releaseExclusiveGroups(pickleID string) {
+	for _, group := range p.pickleIDToExclusiveGroups[pickleID] {
+		delete(p.runningExclusiveGroups, group)
+	}
+	delete(p.pickleIDToExclusiveGroups, pickleID)
+}
+
+func (p *parallelTestCaseRunnerMaster) runNextTestCase(pickle *messages.Pickle, isSkipped bool, onFinish chan *runNextTestCaseResult) {
 	go func() {
 		testCaseRunner, err := NewTestCaseRunner(&NewTestCaseRunnerOptions{
 			BaseDirectory:               p.baseDirectory,
+			IsCancelled:                 p.isCancelled,
 			IsSkipped:                   isSkipped,
 			Pickle:                      pickle,
+			RetryCount:                  getRetryCount(pickle, p.retryCount, p.retryTagExpression),
 			SendCommand:                 p.sendCommand,
 			SendCommandAndAwaitResponse: p.sendCommandAndAwaitResponse,
 			SupportCodeLibrary:          p.supportCodeLibrary,
+			TransformParameters:         p.transformParameters,
 		})
 		if err != nil {
 			onFinish <- &runNextTestCaseResult{err: err}
+			return
+		}
+		testCaseResult := testCaseRunner.Run()
+		p.stepDefinitionUsage.record(pickle, testCaseRunner.GetStepDefinitions(), testCaseRunner.GetTestStepResults())
+		onFinish <- &runNextTestCaseResult{
+			pickleID:        pickle.Id,
+			testCaseResult:  testCaseResult,
+			testStepResults: testCaseRunner.GetTestStepResults(),
 		}
-		onFinish <- &runNextTestCaseResult{testCaseResult: testCaseRunner.Run()}
 	}()
 }
@@ -0,0 +1,53 @@
+package runner
+
+import (
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"strings"
+
+	messages "github.com/cucumber/cucumber-messages-go/v3"
+)
+
+// getParameterValues returns the typed value of each pattern match
+func getParameterValues(patternMatches []*messages.PatternMatch) []interface{} {
+	result := make([]interface{}, len(patternMatches))
+	for i, patternMatch := range patternMatches {
+		result[i] = getParameterValue(patternMatch)
+	}
+	return result
+}
+
+// getParameterValue converts the captures of the built-in parameter types. Integers are
+// json numbers of any size, floats are float64 and strings have their quotes stripped.
+// Returns nil for parameter types defined by the support code, the caller transforms those
+func getParameterValue(patternMatch *messages.PatternMatch) interface{} {
+	captures := patternMatch.Captures
+	switch patternMatch.ParameterTypeName {
+	case "int":
+		if len(captures) == 1 {
+			if i, ok := new(big.Int).SetString(captures[0], 10); ok {
+				return json.Number(i.String())
+			}
+		}
+	case "float":
+		if len(captures) == 1 {
+			if f, err := strconv.ParseFloat(captures[0], 64); err == nil {
+				return f
+			}
+		}
+	case "word", "":
+		if len(captures) == 1 {
+			return captures[0]
+		}
+	case "string":
+		// the first capture is the text between double quotes, the second between single quotes
+		if len(captures) == 2 {
+			if captures[0] != "" {
+				return strings.Replace(captures[0], `\"`, `"`, -1)
+			}
+			return strings.Replace(captures[1], `\'`, `'`, -1)
+		}
+	}
+	return nil
+}
@@ -70,9 +70,5 @@ func (p *PickleFilter) matchesAnyName(pickle *messages.Pickle) bool {
 }
 
 func (p *PickleFilter) matchesTagExpression(pickle *messages.Pickle) bool {
-	tagNames := make([]string, len(pickle.Tags))
-	for i, tag := range pickle.Tags {
-		tagNames[i] = tag.Name
-	}
-	return p.tagExpression.Evaluate(tagNames)
+	return p.tagExpression.Evaluate(getPickleTagNames(pickle))
 }
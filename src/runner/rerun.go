@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	messages "github.com/cucumber/cucumber-messages-go/v3"
+)
+
+// rerunFilePrefix marks a path in the sources config as a rerun file
+const rerunFilePrefix = "@"
+
+// rerunEntryRegexp splits an entry of a rerun file into the path and the lines
+var rerunEntryRegexp = regexp.MustCompile(`^(.*?)((?::\d+)*)$`)
+
+// expandRerunFiles replaces each rerun file in the given paths with the features
+// it lists and returns line mappings that select only the listed scenarios.
+// A feature given as a plain path, or listed without lines, keeps all of its scenarios
+// wherever it appears in the paths
+func expandRerunFiles(baseDirectory string, absolutePaths []string) ([]string, []*messages.UriToLinesMapping, error) {
+	paths := []string{}
+	pathToLines := map[string][]uint64{}
+	hasAllLines := map[string]bool{}
+	addPath := func(path string, lines []uint64) {
+		if _, ok := pathToLines[path]; !ok {
+			paths = append(paths, path)
+		}
+		pathToLines[path] = append(pathToLines[path], lines...)
+		if len(lines) == 0 {
+			hasAllLines[path] = true
+		}
+	}
+	for _, absolutePath := range absolutePaths {
+		if !strings.HasPrefix(absolutePath, rerunFilePrefix) {
+			addPath(absolutePath, nil)
+			continue
+		}
+		rerunFilePath := strings.TrimPrefix(absolutePath, rerunFilePrefix)
+		mapping, err := readRerunFile(baseDirectory, rerunFilePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, uriToLines := range mapping {
+			addPath(uriToLines.AbsolutePath, uriToLines.Lines)
+		}
+	}
+	var uriToLinesMapping []*messages.UriToLinesMapping
+	for _, path := range paths {
+		if !hasAllLines[path] {
+			uriToLinesMapping = append(uriToLinesMapping, &messages.UriToLinesMapping{
+				AbsolutePath: path,
+				Lines:        pathToLines[path],
+			})
+		}
+	}
+	return paths, uriToLinesMapping, nil
+}
+
+func readRerunFile(baseDirectory, rerunFilePath string) ([]*messages.UriToLinesMapping, error) {
+	file, err := os.Open(rerunFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	result := []*messages.UriToLinesMapping{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		for _, entry := range strings.Fields(scanner.Text()) {
+			match := rerunEntryRegexp.FindStringSubmatch(entry)
+			path := match[1]
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(baseDirectory, path)
+			}
+			lines := []uint64{}
+			if match[2] != "" {
+				for _, lineText := range strings.Split(match[2][1:], ":") {
+					line, err := strconv.ParseUint(lineText, 10, 64)
+					if err != nil {
+						return nil, fmt.Errorf("Invalid line in rerun file '%s': '%s'", rerunFilePath, entry)
+					}
+					lines = append(lines, line)
+				}
+			}
+			result = append(result, &messages.UriToLinesMapping{
+				AbsolutePath: path,
+				Lines:        lines,
+			})
+		}
+	}
+	return result, scanner.Err()
+}
+
+// writeRerunFile writes the location of each of the given pickles to the rerun file
+// in the format `uri:line:line`, with one line per uri
+func writeRerunFile(baseDirectory, rerunFilePath string, pickles []*messages.Pickle) error {
+	uriToLines := map[string][]int{}
+	for _, pickle := range pickles {
+		uri, err := getRelativeURI(baseDirectory, pickle.Uri)
+		if err != nil {
+			return err
+		}
+		line := pickle.Locations[len(pickle.Locations)-1].Line
+		uriToLines[uri] = append(uriToLines[uri], int(line))
+	}
+	uris := make([]string, 0, len(uriToLines))
+	for uri := range uriToLines {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	var content strings.Builder
+	for _, uri := range uris {
+		lines := uriToLines[uri]
+		sort.Ints(lines)
+		content.WriteString(uri)
+		for _, line := range lines {
+			content.WriteString(":" + strconv.Itoa(line))
+		}
+		content.WriteString("\n")
+	}
+	return ioutil.WriteFile(rerunFilePath, []byte(content.String()), 0644)
+}
@@ -4,44 +4,61 @@ import (
 	"github.com/cucumber/cucumber-engine/src/dto"
 
 	messages "github.com/cucumber/cucumber-messages-go/v3"
+	tagexpressions "github.com/cucumber/tag-expressions-go"
 )
 
 type runTestCasesOptions struct {
 	baseDirectory               string
+	exclusiveGroups             *exclusiveGroups
+	isCancelled                 func() bool
 	pickles                     []*messages.Pickle
+	retryCount                  int
+	retryTagExpression          tagexpressions.Evaluatable
 	runtimeConfig               *messages.RuntimeConfig
+	serialTagExpression         tagexpressions.Evaluatable
+	stepDefinitionUsage         *stepDefinitionUsageRecorder
 	sendCommand                 func(*messages.Envelope)
 	sendCommandAndAwaitResponse func(*messages.Envelope) *messages.Envelope
 	supportCodeLibrary          *SupportCodeLibrary
+	testCaseDurations           testCaseDurations
+	transformParameters         bool
 }
 
 // RunTestCasesInParallel runs the given tests cases in parallel
-func RunTestCasesInParallel(opts *runTestCasesOptions) (bool, error) {
+func RunTestCasesInParallel(opts *runTestCasesOptions) (*dto.TestRunResult, error) {
 	master := newParallelTestCaseRunnerMaster(opts)
 	return master.run()
 }
 
 // RunTestCasesSequentially runs the given tests cases sequentially
-func RunTestCasesSequentially(opts *runTestCasesOptions) (bool, error) {
+func RunTestCasesSequentially(opts *runTestCasesOptions) (*dto.TestRunResult, error) {
 	testRunResult := dto.NewTestRunResult()
 	isSkipped := opts.runtimeConfig.IsDryRun
 	for _, pickle := range opts.pickles {
+		if opts.isCancelled() {
+			break
+		}
 		testCaseRunner, err := NewTestCaseRunner(&NewTestCaseRunnerOptions{
 			BaseDirectory:               opts.baseDirectory,
+			IsCancelled:                 opts.isCancelled,
 			IsSkipped:                   isSkipped,
 			Pickle:                      pickle,
+			RetryCount:                  getRetryCount(pickle, opts.retryCount, opts.retryTagExpression),
 			SendCommand:                 opts.sendCommand,
 			SendCommandAndAwaitResponse: opts.sendCommandAndAwaitResponse,
 			SupportCodeLibrary:          opts.supportCodeLibrary,
+			TransformParameters:         opts.transformParameters,
 		})
 		if err != nil {
-			return false, err
+			return nil, err
 		}
 		testCaseResult := testCaseRunner.Run()
-		testRunResult.Update(testCaseResult, opts.runtimeConfig.IsStrict)
+		opts.stepDefinitionUsage.record(pickle, testCaseRunner.GetStepDefinitions(), testCaseRunner.GetTestStepResults())
+		opts.testCaseDurations.record(pickle.Id, testCaseResult)
+		testRunResult.Update(pickle.Id, testCaseResult, testCaseRunner.GetTestStepResults(), opts.runtimeConfig.IsStrict)
 		if !isSkipped && !testRunResult.Success && opts.runtimeConfig.IsFailFast {
 			isSkipped = true
 		}
 	}
-	return testRunResult.Success, nil
+	return testRunResult, nil
 }
@@ -1,19 +1,27 @@
 package runner
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
-	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/cucumber/cucumber-engine/src/dto"
+	"github.com/cucumber/cucumber-engine/src/dto/event"
 	messages "github.com/cucumber/cucumber-messages-go/v3"
 	gherkin "github.com/cucumber/gherkin-go"
+	tagexpressions "github.com/cucumber/tag-expressions-go"
+	"github.com/gogo/protobuf/proto"
 	uuid "github.com/satori/go.uuid"
 )
 
 // Runner executes a run of cucumber
 type Runner struct {
+	cancelOnce           sync.Once
+	cancelled            chan struct{}
+	engineConfig         *dto.EngineConfig
 	incomingCommands     chan *messages.Envelope
 	outgoingCommands     chan *messages.Envelope
 	responseChannelMutex sync.RWMutex
@@ -22,8 +30,10 @@ type Runner struct {
 }
 
 // NewRunner creates a runner
-func NewRunner() *Runner {
+func NewRunner(engineConfig *dto.EngineConfig) *Runner {
 	r := &Runner{
+		cancelled:        make(chan struct{}),
+		engineConfig:     engineConfig,
 		incomingCommands: make(chan *messages.Envelope),
 		outgoingCommands: make(chan *messages.Envelope),
 		responseChannels: map[string]chan *messages.Envelope{},
@@ -31,6 +41,11 @@ func NewRunner() *Runner {
 	}
 	go func() {
 		for command := range r.incomingCommands {
+			// a cancel is handled before the responses received after it
+			if isCancelTestRunCommand(command) {
+				r.Cancel()
+				continue
+			}
 			go r.receiveCommand(command)
 		}
 	}()
@@ -42,6 +57,23 @@ func (r *Runner) GetCommandChannels() (chan *messages.Envelope, chan *messages.E
 	return r.incomingCommands, r.outgoingCommands
 }
 
+// Cancel stops the run from starting new test cases and skips the remaining
+// steps of the running ones. Test case and test run hooks that clean up still run
+func (r *Runner) Cancel() {
+	r.cancelOnce.Do(func() {
+		close(r.cancelled)
+	})
+}
+
+func (r *Runner) isCancelled() bool {
+	select {
+	case <-r.cancelled:
+		return true
+	default:
+		return false
+	}
+}
+
 func (r *Runner) receiveCommand(command *messages.Envelope) {
 	switch x := command.Message.(type) {
 	case *messages.Envelope_CommandStart:
@@ -55,6 +87,10 @@ func (r *Runner) receiveCommand(command *messages.Envelope) {
 	}
 }
 
+func isCancelTestRunCommand(command *messages.Envelope) bool {
+	return command.GetAttachment().GetMedia().GetContentType() == event.CancelTestRunContentType
+}
+
 func (r *Runner) sendCommand(command *messages.Envelope) {
 	r.outgoingCommands <- command
 }
@@ -68,135 +104,460 @@ func (r *Runner) sendError(err error) {
 }
 
 func (r *Runner) start(command *messages.CommandStart) {
-	acceptedPickles, err := r.getAcceptedPickles(command.GetBaseDirectory(), command.SourcesConfig)
+	defer close(r.outgoingCommands)
+	previousTimings, err := r.getPreviousTimings()
+	if err != nil {
+		r.sendError(err)
+		return
+	}
+	acceptedPickles, hasParseErrors, err := r.getAcceptedPickles(command.GetBaseDirectory(), command.SourcesConfig, previousTimings)
 	if err != nil {
 		r.sendError(err)
 		return
 	}
 	supportCodeLibrary, err := NewSupportCodeLibrary(command.SupportCodeConfig)
+	if err != nil {
+		if configError, ok := err.(*SupportCodeConfigError); ok {
+			if uriErr := configError.makeURIsRelative(command.BaseDirectory); uriErr != nil {
+				r.sendError(uriErr)
+				return
+			}
+			r.sendSupportCodeErrorsEvent(configError)
+		}
+		r.sendError(err)
+		return
+	}
+	err = r.validateStepDefinitions(command.BaseDirectory, supportCodeLibrary)
 	if err != nil {
 		r.sendError(err)
 		return
 	}
+	retryTagExpression, err := tagexpressions.Parse(r.engineConfig.RetryTagExpression)
+	if err != nil {
+		r.sendError(err)
+		return
+	}
+	exclusiveGroups, err := newExclusiveGroups(r.engineConfig.ExclusiveTagPrefixes, r.engineConfig.ExclusiveTagExpressions)
+	if err != nil {
+		r.sendError(err)
+		return
+	}
+	var serialTagExpression tagexpressions.Evaluatable
+	if r.engineConfig.SerialTagExpression != "" {
+		serialTagExpression, err = tagexpressions.Parse(r.engineConfig.SerialTagExpression)
+		if err != nil {
+			r.sendError(err)
+			return
+		}
+	}
 	r.sendCommand(&messages.Envelope{
 		Message: &messages.Envelope_TestRunStarted{
 			TestRunStarted: &messages.TestRunStarted{},
 		},
 	})
+	testRunHooksTimedOut := false
 	if len(acceptedPickles) > 0 {
-		_ = r.sendCommandAndAwaitResponse(&messages.Envelope{
+		_, testRunHooksTimedOut = r.sendCommandAndAwaitResponseOrTimeout(&messages.Envelope{
 			Message: &messages.Envelope_CommandRunBeforeTestRunHooks{
 				CommandRunBeforeTestRunHooks: &messages.CommandRunBeforeTestRunHooks{},
 			},
 		})
 	}
-	var runTestCasesFunc func(*runTestCasesOptions) (bool, error)
+	var stepDefinitionUsage *stepDefinitionUsageRecorder
+	if r.engineConfig.StepDefinitionUsage {
+		stepDefinitionUsage = newStepDefinitionUsageRecorder()
+	}
+	durations := testCaseDurations{}
+	var runTestCasesFunc func(*runTestCasesOptions) (*dto.TestRunResult, error)
 	if command.RuntimeConfig.MaxParallel == 0 || command.RuntimeConfig.MaxParallel > 1 {
 		runTestCasesFunc = RunTestCasesInParallel
+		if r.engineConfig.ScheduleOrder == dto.ScheduleOrderLongestFirst {
+			err = previousTimings.sortLongestFirst(command.BaseDirectory, acceptedPickles)
+			if err != nil {
+				r.sendError(err)
+				return
+			}
+		}
 	} else {
 		runTestCasesFunc = RunTestCasesSequentially
 	}
 	testRunResult, err := runTestCasesFunc(&runTestCasesOptions{
 		baseDirectory:               command.BaseDirectory,
+		exclusiveGroups:             exclusiveGroups,
+		isCancelled:                 r.isCancelled,
 		pickles:                     acceptedPickles,
+		retryCount:                  r.engineConfig.RetryCount,
+		retryTagExpression:          retryTagExpression,
 		runtimeConfig:               command.RuntimeConfig,
+		serialTagExpression:         serialTagExpression,
+		stepDefinitionUsage:         stepDefinitionUsage,
+		testCaseDurations:           durations,
 		sendCommand:                 r.sendCommand,
 		sendCommandAndAwaitResponse: r.sendCommandAndAwaitResponse,
 		supportCodeLibrary:          supportCodeLibrary,
+		transformParameters:         r.engineConfig.TransformParameters,
 	})
 	if err != nil {
 		r.sendError(err)
 		return
 	}
 	if len(acceptedPickles) > 0 {
-		_ = r.sendCommandAndAwaitResponse(&messages.Envelope{
+		_, afterTestRunHooksTimedOut := r.sendCommandAndAwaitResponseOrTimeout(&messages.Envelope{
 			Message: &messages.Envelope_CommandRunAfterTestRunHooks{
 				CommandRunAfterTestRunHooks: &messages.CommandRunAfterTestRunHooks{},
 			},
 		})
+		testRunHooksTimedOut = testRunHooksTimedOut || afterTestRunHooksTimedOut
+	}
+	if r.isCancelled() {
+		testRunResult.Success = false
+		r.sendTestRunCancelledEvent()
 	}
+	if hasParseErrors || testRunHooksTimedOut {
+		testRunResult.Success = false
+	}
+	if r.engineConfig.RerunFilePath != "" {
+		err = writeRerunFile(command.BaseDirectory, r.engineConfig.RerunFilePath, getPicklesWithIds(acceptedPickles, testRunResult.FailingPickleIds))
+		if err != nil {
+			r.sendError(err)
+		}
+	}
+	if r.engineConfig.TimingsFilePath != "" {
+		err = r.writeTimings(command.BaseDirectory, previousTimings, acceptedPickles, durations)
+		if err != nil {
+			r.sendError(err)
+		}
+	}
+	if stepDefinitionUsage != nil {
+		r.sendStepDefinitionUsageEvent(command.BaseDirectory, stepDefinitionUsage, supportCodeLibrary)
+	}
+	if r.engineConfig.DebugWriter != nil {
+		hits, misses := supportCodeLibrary.GetStepMatchCacheStats()
+		fmt.Fprintf(r.engineConfig.DebugWriter, "cucumber-engine: step match cache: %d hits, %d misses\n", hits, misses)
+	}
+	r.sendTestRunSummaryEvent(testRunResult)
 	r.sendCommand(&messages.Envelope{
 		Message: &messages.Envelope_TestRunFinished{
-			TestRunFinished: &messages.TestRunFinished{Success: testRunResult},
+			TestRunFinished: &messages.TestRunFinished{Success: testRunResult.Success},
+		},
+	})
+}
+
+// validateStepDefinitions sends a warning for each problem with the step definitions,
+// or returns an error listing them if step definitions are strict. Overlaps are not
+// certain, so they are only warned about
+func (r *Runner) validateStepDefinitions(baseDirectory string, supportCodeLibrary *SupportCodeLibrary) error {
+	problems := validateStepDefinitions(supportCodeLibrary.GetStepDefinitions(), supportCodeLibrary.stepDefinitionIndex)
+	if len(problems) == 0 {
+		return nil
+	}
+	warnings := make([]*event.StepDefinitionWarning, len(problems))
+	for i, problem := range problems {
+		warnings[i] = &event.StepDefinitionWarning{
+			Kind:            problem.kind,
+			Message:         problem.message,
+			StepDefinitions: make([]*event.StepDefinitionReference, len(problem.stepDefinitions)),
+		}
+		for j, stepDefinition := range problem.stepDefinitions {
+			var err error
+			warnings[i].StepDefinitions[j], err = getStepDefinitionReference(baseDirectory, stepDefinition)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	lines := []string{}
+	for _, warning := range warnings {
+		if r.engineConfig.StrictStepDefinitions && warning.Kind != overlapPatternProblem {
+			lines = append(lines, "  "+getStepDefinitionWarningDescription(warning))
+			continue
+		}
+		attachment, err := event.NewJSONAttachment(event.StepDefinitionWarningContentType, warning)
+		if err != nil {
+			return err
+		}
+		r.sendCommand(&messages.Envelope{
+			Message: &messages.Envelope_Attachment{
+				Attachment: attachment,
+			},
+		})
+	}
+	if len(lines) > 0 {
+		return fmt.Errorf("Step definitions match the same steps:\n%s", strings.Join(lines, "\n"))
+	}
+	return nil
+}
+
+func (r *Runner) getPreviousTimings() (timings, error) {
+	if r.engineConfig.TimingsFilePath == "" {
+		if r.engineConfig.ScheduleOrder == dto.ScheduleOrderLongestFirst {
+			return nil, errors.New("Scheduling the longest test cases first requires a timings file")
+		}
+		return timings{}, nil
+	}
+	return readTimingsFile(r.engineConfig.TimingsFilePath)
+}
+
+func (r *Runner) writeTimings(baseDirectory string, previousTimings timings, pickles []*messages.Pickle, durations testCaseDurations) error {
+	err := previousTimings.update(baseDirectory, pickles, durations)
+	if err != nil {
+		return err
+	}
+	return writeTimingsFile(r.engineConfig.TimingsFilePath, previousTimings)
+}
+
+func (r *Runner) sendTestRunCancelledEvent() {
+	attachment, err := event.NewJSONAttachment(event.TestRunCancelledContentType, &event.TestRunCancelled{})
+	if err != nil {
+		r.sendError(err)
+		return
+	}
+	r.sendCommand(&messages.Envelope{
+		Message: &messages.Envelope_Attachment{
+			Attachment: attachment,
+		},
+	})
+}
+
+func (r *Runner) sendStepDefinitionUsageEvent(baseDirectory string, stepDefinitionUsage *stepDefinitionUsageRecorder, supportCodeLibrary *SupportCodeLibrary) {
+	report, err := stepDefinitionUsage.getReport(baseDirectory, supportCodeLibrary.GetStepDefinitions())
+	if err != nil {
+		r.sendError(err)
+		return
+	}
+	attachment, err := event.NewJSONAttachment(event.StepDefinitionUsageContentType, report)
+	if err != nil {
+		r.sendError(err)
+		return
+	}
+	r.sendCommand(&messages.Envelope{
+		Message: &messages.Envelope_Attachment{
+			Attachment: attachment,
+		},
+	})
+}
+
+func (r *Runner) sendSupportCodeErrorsEvent(configError *SupportCodeConfigError) {
+	attachment, err := event.NewJSONAttachment(event.SupportCodeErrorsContentType, &event.SupportCodeErrors{Errors: configError.Errors})
+	if err != nil {
+		r.sendError(err)
+		return
+	}
+	r.sendCommand(&messages.Envelope{
+		Message: &messages.Envelope_Attachment{
+			Attachment: attachment,
 		},
 	})
-	close(r.outgoingCommands)
 }
 
-func (r *Runner) getAcceptedPickles(baseDirectory string, sourcesConfig *messages.SourcesConfig) ([]*messages.Pickle, error) {
-	pickleFilter, err := NewPickleFilter(sourcesConfig.Filters)
+func (r *Runner) sendTestRunSummaryEvent(testRunResult *dto.TestRunResult) {
+	attachment, err := event.NewJSONAttachment(event.TestRunSummaryContentType, testRunResult)
 	if err != nil {
-		return nil, err
+		r.sendError(err)
+		return
+	}
+	r.sendCommand(&messages.Envelope{
+		Message: &messages.Envelope_Attachment{
+			Attachment: attachment,
+		},
+	})
+}
+
+// getAcceptedPickles parses the sources, sending the gherkin messages, and returns the pickles to run.
+// Every parse error is sent. If there are any, an error listing them is returned unless
+// parse errors are allowed, in which case the pickles of the valid sources are returned
+func (r *Runner) getAcceptedPickles(baseDirectory string, sourcesConfig *messages.SourcesConfig, previousTimings timings) ([]*messages.Pickle, bool, error) {
+	err := validateShard(r.engineConfig.ShardIndex, r.engineConfig.ShardTotal)
+	if err != nil {
+		return nil, false, err
 	}
-	gherkinMessages, err := gherkin.Messages(sourcesConfig.AbsolutePaths, nil, sourcesConfig.Language, true, true, true, nil, false)
+	absolutePaths, rerunUriToLinesMapping, err := expandRerunFiles(baseDirectory, sourcesConfig.AbsolutePaths)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	filters := &messages.SourcesFilterConfig{}
+	if sourcesConfig.GetFilters() != nil {
+		filters = proto.Clone(sourcesConfig.Filters).(*messages.SourcesFilterConfig)
 	}
+	filters.UriToLinesMapping = append(rerunUriToLinesMapping, filters.UriToLinesMapping...)
+	pickleFilter, err := NewPickleFilter(filters)
+	if err != nil {
+		return nil, false, err
+	}
+	gherkinMessages, err := gherkin.Messages(absolutePaths, nil, sourcesConfig.Language, true, true, true, nil, false)
+	if err != nil {
+		return nil, false, err
+	}
+	pickles := []*messages.Pickle{}
 	acceptedPickles := []*messages.Pickle{}
+	parseErrors := []string{}
 	for i, gherkinMessage := range gherkinMessages {
 		switch x := gherkinMessage.Message.(type) {
 		case *messages.Envelope_Attachment:
-			uri, err := filepath.Rel(baseDirectory, x.Attachment.Source.Uri)
+			uri, err := getRelativeURI(baseDirectory, x.Attachment.Source.Uri)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
-			return nil, fmt.Errorf("Parse error in '%s': %s", uri, x.Attachment.Data)
+			r.sendCommand(&gherkinMessages[i])
+			parseErrors = append(parseErrors, fmt.Sprintf("Parse error in '%s': %s", uri, x.Attachment.Data))
 		case *messages.Envelope_Pickle:
 			pickle := x.Pickle
-			pickle.Id = uuid.NewV4().String()
+			if r.engineConfig.StablePickleIds {
+				pickle.Id, err = getStablePickleID(baseDirectory, pickle)
+				if err != nil {
+					return nil, false, err
+				}
+			} else {
+				pickle.Id = uuid.NewV4().String()
+			}
 			r.sendCommand(&gherkinMessages[i])
-			if pickleFilter.Matches(pickle) {
-				r.sendCommand(&messages.Envelope{
-					Message: &messages.Envelope_PickleAccepted{
-						PickleAccepted: &messages.PickleAccepted{PickleId: pickle.Id},
-					},
-				})
+			pickles = append(pickles, pickle)
+			isAccepted := pickleFilter.Matches(pickle)
+			if isAccepted {
 				acceptedPickles = append(acceptedPickles, pickle)
-			} else {
-				r.sendCommand(&messages.Envelope{
-					Message: &messages.Envelope_PickleRejected{
-						PickleRejected: &messages.PickleRejected{PickleId: pickle.Id},
-					},
-				})
+			}
+			if r.engineConfig.ShardTotal == 0 {
+				r.sendPickleAcceptedOrRejectedEvent(pickle.Id, isAccepted)
 			}
 		default:
 			r.sendCommand(&gherkinMessages[i])
 		}
 	}
+	if len(parseErrors) > 0 && !r.engineConfig.ContinueOnParseErrors {
+		return nil, false, errors.New(strings.Join(parseErrors, "\n"))
+	}
+	if r.engineConfig.ShardTotal > 0 {
+		acceptedPickles, err = getShardPickles(baseDirectory, acceptedPickles, r.engineConfig.ShardIndex, r.engineConfig.ShardTotal, r.engineConfig.TimingsFilePath != "", previousTimings)
+		if err != nil {
+			return nil, false, err
+		}
+		// the shards are only known once every pickle is parsed
+		r.sendPickleAcceptedAndRejectedEvents(pickles, acceptedPickles)
+	}
 	if sourcesConfig.Order.Type == messages.SourcesOrderType_RANDOM {
 		reorderPickles(acceptedPickles, sourcesConfig.Order.Seed)
 	}
-	return acceptedPickles, nil
+	return acceptedPickles, len(parseErrors) > 0, nil
+}
+
+func (r *Runner) sendPickleAcceptedAndRejectedEvents(pickles, acceptedPickles []*messages.Pickle) {
+	isAccepted := map[string]bool{}
+	for _, pickle := range acceptedPickles {
+		isAccepted[pickle.Id] = true
+	}
+	for _, pickle := range pickles {
+		r.sendPickleAcceptedOrRejectedEvent(pickle.Id, isAccepted[pickle.Id])
+	}
+}
+
+func (r *Runner) sendPickleAcceptedOrRejectedEvent(pickleID string, isAccepted bool) {
+	if isAccepted {
+		r.sendCommand(&messages.Envelope{
+			Message: &messages.Envelope_PickleAccepted{
+				PickleAccepted: &messages.PickleAccepted{PickleId: pickleID},
+			},
+		})
+	} else {
+		r.sendCommand(&messages.Envelope{
+			Message: &messages.Envelope_PickleRejected{
+				PickleRejected: &messages.PickleRejected{PickleId: pickleID},
+			},
+		})
+	}
 }
 
 func (r *Runner) sendCommandAndAwaitResponse(command *messages.Envelope) *messages.Envelope {
+	response, _ := r.sendCommandAndAwaitResponseOrTimeout(command)
+	return response
+}
+
+// sendCommandAndAwaitResponseOrTimeout returns the response to the command and whether
+// it timed out, in which case the response is a failed test result
+func (r *Runner) sendCommandAndAwaitResponseOrTimeout(command *messages.Envelope) (*messages.Envelope, bool) {
 	id := uuid.NewV4().String()
+	var timeout time.Duration
+	var actionDescription string
 	switch x := command.Message.(type) {
 	case *messages.Envelope_CommandRunBeforeTestRunHooks:
 		x.CommandRunBeforeTestRunHooks.ActionId = id
+		timeout, actionDescription = r.engineConfig.TestRunHookTimeout, "before test run hooks"
 	case *messages.Envelope_CommandRunAfterTestRunHooks:
 		x.CommandRunAfterTestRunHooks.ActionId = id
+		timeout, actionDescription = r.engineConfig.TestRunHookTimeout, "after test run hooks"
 	case *messages.Envelope_CommandInitializeTestCase:
 		x.CommandInitializeTestCase.ActionId = id
 	case *messages.Envelope_CommandRunBeforeTestCaseHook:
 		x.CommandRunBeforeTestCaseHook.ActionId = id
+		timeout, actionDescription = r.engineConfig.TestCaseHookTimeout, "before test case hook"
 	case *messages.Envelope_CommandRunAfterTestCaseHook:
 		x.CommandRunAfterTestCaseHook.ActionId = id
+		timeout, actionDescription = r.engineConfig.TestCaseHookTimeout, "after test case hook"
 	case *messages.Envelope_CommandRunTestStep:
 		x.CommandRunTestStep.ActionId = id
+		timeout, actionDescription = r.engineConfig.TestStepTimeout, "test step"
 	case *messages.Envelope_CommandGenerateSnippet:
 		x.CommandGenerateSnippet.ActionId = id
+		timeout, actionDescription = r.engineConfig.GenerateSnippetTimeout, "snippet generation"
 	}
-	responseChannel := make(chan *messages.Envelope)
+	// buffered so a response arriving after a timeout never blocks receiveCommand
+	responseChannel := make(chan *messages.Envelope, 1)
 	r.responseChannelMutex.Lock()
 	r.responseChannels[id] = responseChannel
 	r.responseChannelMutex.Unlock()
-	go r.sendCommand(command)
-	result := <-responseChannel
+	sent := make(chan struct{})
+	go func() {
+		r.sendCommand(command)
+		close(sent)
+	}()
+	var result *messages.Envelope
+	timedOut := false
+	if timeout == 0 {
+		result = <-responseChannel
+	} else {
+		select {
+		case result = <-responseChannel:
+		case <-time.After(timeout):
+			// the timeout must follow the command so the command is routed first
+			<-sent
+			result = r.getTimeoutResponse(id, timeout, actionDescription)
+			timedOut = true
+		}
+	}
 	r.responseChannelMutex.Lock()
 	delete(r.responseChannels, id)
 	r.responseChannelMutex.Unlock()
-	return result
+	return result, timedOut
+}
+
+func (r *Runner) getTimeoutResponse(id string, timeout time.Duration, actionDescription string) *messages.Envelope {
+	attachment, err := event.NewJSONAttachment(event.ActionTimeoutContentType, &event.ActionTimeout{
+		ActionID:            id,
+		Action:              actionDescription,
+		TimeoutMilliseconds: int64(timeout / time.Millisecond),
+	})
+	if err != nil {
+		r.sendError(err)
+	} else {
+		r.sendCommand(&messages.Envelope{
+			Message: &messages.Envelope_Attachment{
+				Attachment: attachment,
+			},
+		})
+	}
+	return &messages.Envelope{
+		Message: &messages.Envelope_CommandActionComplete{
+			CommandActionComplete: &messages.CommandActionComplete{
+				CompletedId: id,
+				Result: &messages.CommandActionComplete_TestResult{
+					TestResult: &messages.TestResult{
+						Status:              messages.TestResult_FAILED,
+						Message:             fmt.Sprintf("Timed out after %s waiting for the %s to complete", timeout, actionDescription),
+						DurationNanoseconds: uint64(timeout),
+					},
+				},
+			},
+		},
+	}
 }
 
 func reorderPickles(pickles []*messages.Pickle, seed uint64) {
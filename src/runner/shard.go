@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	messages "github.com/cucumber/cucumber-messages-go/v3"
+)
+
+// validateShard returns an error if the shard index is not within the shard total.
+// A shard total of 0 disables sharding
+func validateShard(shardIndex, shardTotal int) error {
+	if shardTotal < 0 {
+		return fmt.Errorf("Invalid shard total %d, expected a positive number", shardTotal)
+	}
+	if shardTotal > 0 && (shardIndex < 0 || shardIndex >= shardTotal) {
+		return fmt.Errorf("Invalid shard index %d, expected 0 to %d", shardIndex, shardTotal-1)
+	}
+	return nil
+}
+
+// getShardPickles returns the pickles that belong to the shard with the given index.
+// If balanced, the pickles are spread so that each shard has about the same total
+// duration according to the timings, otherwise by a hash of their location
+func getShardPickles(baseDirectory string, pickles []*messages.Pickle, shardIndex, shardTotal int, balanced bool, t timings) ([]*messages.Pickle, error) {
+	var pickleIDToShard map[string]int
+	var err error
+	if balanced {
+		pickleIDToShard, err = getBalancedShards(baseDirectory, pickles, shardTotal, t)
+	} else {
+		pickleIDToShard, err = getHashedShards(baseDirectory, pickles, shardTotal)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := []*messages.Pickle{}
+	for _, pickle := range pickles {
+		if pickleIDToShard[pickle.Id] == shardIndex {
+			result = append(result, pickle)
+		}
+	}
+	return result, nil
+}
+
+func getHashedShards(baseDirectory string, pickles []*messages.Pickle, shardTotal int) (map[string]int, error) {
+	result := make(map[string]int, len(pickles))
+	for _, pickle := range pickles {
+		location, err := getPickleLocation(baseDirectory, pickle)
+		if err != nil {
+			return nil, err
+		}
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(location))
+		result[pickle.Id] = int(hash.Sum32() % uint32(shardTotal))
+	}
+	return result, nil
+}
+
+// getBalancedShards assigns the longest pickles first, each to the shard with the
+// lowest total duration, then the fewest pickles, then the lowest index
+func getBalancedShards(baseDirectory string, pickles []*messages.Pickle, shardTotal int, t timings) (map[string]int, error) {
+	durations, err := t.estimateDurations(baseDirectory, pickles)
+	if err != nil {
+		return nil, err
+	}
+	sortedPickles := append([]*messages.Pickle{}, pickles...)
+	sort.SliceStable(sortedPickles, func(i, j int) bool {
+		return durations[sortedPickles[i].Id] > durations[sortedPickles[j].Id]
+	})
+	shardDurations := make([]uint64, shardTotal)
+	shardCounts := make([]int, shardTotal)
+	result := make(map[string]int, len(pickles))
+	for _, pickle := range sortedPickles {
+		shard := 0
+		for i := 1; i < shardTotal; i++ {
+			if shardDurations[i] < shardDurations[shard] || (shardDurations[i] == shardDurations[shard] && shardCounts[i] < shardCounts[shard]) {
+				shard = i
+			}
+		}
+		shardDurations[shard] += durations[pickle.Id]
+		shardCounts[shard]++
+		result[pickle.Id] = shard
+	}
+	return result, nil
+}
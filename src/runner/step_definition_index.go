@@ -0,0 +1,138 @@
+package runner
+
+import (
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	"github.com/cucumber/cucumber-engine/src/dto"
+)
+
+// stepDefinitionIndex narrows down the step definitions that can match a step text
+// without running their expressions. Each step definition is indexed by a word that
+// appears in every text it matches. Step definitions without such a word are always
+// candidates
+type stepDefinitionIndex struct {
+	stepDefinitionIndexesByWord map[string][]int
+	unindexedStepDefinitions    []int
+}
+
+type requiredLiteral struct {
+	text               string
+	isAtStart, isAtEnd bool
+}
+
+func newStepDefinitionIndex(stepDefinitions []*dto.StepDefinition) *stepDefinitionIndex {
+	stepDefinitionWords := make([][]string, len(stepDefinitions))
+	wordCounts := map[string]int{}
+	for i, stepDefinition := range stepDefinitions {
+		// an expression that fails to match (ambiguous parameter types) fails for any
+		// text, keep it a candidate so the error is still returned
+		if _, err := stepDefinition.Expression.Match(""); err != nil {
+			continue
+		}
+		stepDefinitionWords[i] = getRequiredWords(stepDefinition.Expression.Regexp().String())
+		for _, word := range stepDefinitionWords[i] {
+			wordCounts[word]++
+		}
+	}
+	index := &stepDefinitionIndex{
+		stepDefinitionIndexesByWord: map[string][]int{},
+		unindexedStepDefinitions:    []int{},
+	}
+	for i, words := range stepDefinitionWords {
+		if len(words) == 0 {
+			index.unindexedStepDefinitions = append(index.unindexedStepDefinitions, i)
+			continue
+		}
+		// index by the least common word to keep the candidates for a text few
+		key := words[0]
+		for _, word := range words[1:] {
+			if wordCounts[word] < wordCounts[key] || (wordCounts[word] == wordCounts[key] && len(word) > len(key)) {
+				key = word
+			}
+		}
+		index.stepDefinitionIndexesByWord[key] = append(index.stepDefinitionIndexesByWord[key], i)
+	}
+	return index
+}
+
+// getCandidates returns the indexes of the step definitions that can match the text,
+// in the order of the step definitions
+func (s *stepDefinitionIndex) getCandidates(text string) []int {
+	result := append([]int{}, s.unindexedStepDefinitions...)
+	seenWords := map[string]bool{}
+	for _, word := range strings.Split(text, " ") {
+		if seenWords[word] {
+			continue
+		}
+		seenWords[word] = true
+		result = append(result, s.stepDefinitionIndexesByWord[word]...)
+	}
+	sort.Ints(result)
+	return result
+}
+
+// getRequiredWords returns the space delimited words that appear in every text the
+// regular expression matches. A word at the edge of a literal only counts if the
+// literal is anchored to the start / end of the text, as otherwise the text may
+// continue the word
+func getRequiredWords(source string) []string {
+	re, err := syntax.Parse(source, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	result := []string{}
+	for _, literal := range getRequiredLiterals(re) {
+		parts := strings.Split(literal.text, " ")
+		for i, part := range parts {
+			if part == "" || (i == 0 && !literal.isAtStart) || (i == len(parts)-1 && !literal.isAtEnd) {
+				continue
+			}
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+// getRequiredLiterals returns the runs of literal text in the top level concatenation
+// of the regular expression, looking through capture groups
+func getRequiredLiterals(re *syntax.Regexp) []*requiredLiteral {
+	result := []*requiredLiteral{}
+	current := &requiredLiteral{}
+	flush := func() {
+		if current.text != "" {
+			result = append(result, current)
+		}
+		current = &requiredLiteral{}
+	}
+	var walk func(re *syntax.Regexp)
+	walk = func(re *syntax.Regexp) {
+		switch re.Op {
+		case syntax.OpConcat:
+			for _, sub := range re.Sub {
+				walk(sub)
+			}
+		case syntax.OpCapture:
+			walk(re.Sub[0])
+		case syntax.OpEmptyMatch:
+		case syntax.OpLiteral:
+			if re.Flags&syntax.FoldCase != 0 {
+				flush()
+				return
+			}
+			current.text += string(re.Rune)
+		case syntax.OpBeginText:
+			flush()
+			current.isAtStart = true
+		case syntax.OpEndText:
+			current.isAtEnd = true
+			flush()
+		default:
+			flush()
+		}
+	}
+	walk(re)
+	flush()
+	return result
+}
@@ -0,0 +1,169 @@
+package runner
+
+import (
+	"math"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cucumber/cucumber-engine/src/dto"
+)
+
+// The kinds of step definition suggestions
+const (
+	parameterMismatchSuggestion = "parameter"
+	similarTextSuggestion       = "text"
+)
+
+// maxStepDefinitionSuggestions limits the number of suggestions for an undefined step
+const maxStepDefinitionSuggestions = 3
+
+// unknownSuggestionDistance is the distance of a parameter mismatch whose step definition
+// has no example texts. It sorts after the other parameter mismatches and is not sent
+const unknownSuggestionDistance = math.MaxInt32
+
+// stepDefinitionSuggestion is a step definition that almost matches an undefined step
+type stepDefinitionSuggestion struct {
+	distance       int
+	kind           string
+	stepDefinition *dto.StepDefinition
+}
+
+// stepDefinitionSuggester finds the step definitions closest to an undefined step.
+// The example texts and parameter skeletons of the step definitions are computed
+// on first use, as most runs have no undefined steps
+type stepDefinitionSuggester struct {
+	once            sync.Once
+	samples         [][]string
+	skeletons       []*regexp.Regexp
+	stepDefinitions []*dto.StepDefinition
+}
+
+func newStepDefinitionSuggester(stepDefinitions []*dto.StepDefinition) *stepDefinitionSuggester {
+	return &stepDefinitionSuggester{stepDefinitions: stepDefinitions}
+}
+
+func (s *stepDefinitionSuggester) init() {
+	s.samples = make([][]string, len(s.stepDefinitions))
+	s.skeletons = make([]*regexp.Regexp, len(s.stepDefinitions))
+	for i, stepDefinition := range s.stepDefinitions {
+		s.samples[i] = getPatternSamples(stepDefinition)
+		s.skeletons[i] = getParameterSkeleton(stepDefinition)
+	}
+}
+
+// getSuggestions returns the step definitions whose pattern matches the text when
+// any parameter is accepted, followed by the ones whose example texts are a few
+// edits away from the text. Closest first, at most maxStepDefinitionSuggestions
+func (s *stepDefinitionSuggester) getSuggestions(text string) []*stepDefinitionSuggestion {
+	s.once.Do(s.init)
+	maxDistance := len([]rune(text)) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+	result := []*stepDefinitionSuggestion{}
+	for i, stepDefinition := range s.stepDefinitions {
+		if s.skeletons[i] != nil && s.skeletons[i].MatchString(text) {
+			distance := getMinEditDistance(text, s.samples[i], unknownSuggestionDistance-1)
+			result = append(result, &stepDefinitionSuggestion{distance: distance, kind: parameterMismatchSuggestion, stepDefinition: stepDefinition})
+			continue
+		}
+		if distance := getMinEditDistance(text, s.samples[i], maxDistance); distance <= maxDistance {
+			result = append(result, &stepDefinitionSuggestion{distance: distance, kind: similarTextSuggestion, stepDefinition: stepDefinition})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].kind != result[j].kind {
+			return result[i].kind == parameterMismatchSuggestion
+		}
+		return result[i].distance < result[j].distance
+	})
+	if len(result) > maxStepDefinitionSuggestions {
+		result = result[:maxStepDefinitionSuggestions]
+	}
+	return result
+}
+
+// getParameterSkeleton returns the pattern of the step definition where each capture
+// group accepts any text, nil if it has no capture groups
+func getParameterSkeleton(stepDefinition *dto.StepDefinition) *regexp.Regexp {
+	re, err := syntax.Parse(stepDefinition.Expression.Regexp().String(), syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	skeleton, replaced := replaceCaptures(re)
+	if !replaced {
+		return nil
+	}
+	result, err := regexp.Compile(skeleton.String())
+	if err != nil {
+		return nil
+	}
+	return result
+}
+
+func replaceCaptures(re *syntax.Regexp) (*syntax.Regexp, bool) {
+	if re.Op == syntax.OpCapture {
+		return &syntax.Regexp{
+			Op:    syntax.OpStar,
+			Flags: syntax.Perl,
+			Sub:   []*syntax.Regexp{{Op: syntax.OpAnyCharNotNL}},
+		}, true
+	}
+	result := *re
+	result.Sub = make([]*syntax.Regexp, len(re.Sub))
+	replaced := false
+	for i, sub := range re.Sub {
+		var subReplaced bool
+		result.Sub[i], subReplaced = replaceCaptures(sub)
+		replaced = replaced || subReplaced
+	}
+	return &result, replaced
+}
+
+// getMinEditDistance returns the smallest case insensitive edit distance between the text
+// and the samples. Samples whose length differs too much are skipped and count
+// as maxDistance + 1
+func getMinEditDistance(text string, samples []string, maxDistance int) int {
+	a := []rune(strings.ToLower(text))
+	result := maxDistance + 1
+	for _, sample := range samples {
+		b := []rune(strings.ToLower(sample))
+		if len(a)-len(b) >= result || len(b)-len(a) >= result {
+			continue
+		}
+		if distance := getEditDistance(a, b); distance < result {
+			result = distance
+		}
+	}
+	return result
+}
+
+// getEditDistance returns the levenshtein distance between a and b
+func getEditDistance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,118 @@
+package runner
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/cucumber/cucumber-engine/src/dto"
+	"github.com/cucumber/cucumber-engine/src/dto/event"
+	messages "github.com/cucumber/cucumber-messages-go/v3"
+)
+
+// stepDefinitionUsageRecorder collects the pickle steps that match each step definition.
+// Safe for concurrent use, a nil recorder records nothing
+type stepDefinitionUsageRecorder struct {
+	mutex   sync.Mutex
+	matches map[*dto.StepDefinition][]*stepDefinitionMatch
+}
+
+type stepDefinitionMatch struct {
+	pickle *messages.Pickle
+	step   *messages.Pickle_PickleStep
+	result *messages.TestResult
+}
+
+func newStepDefinitionUsageRecorder() *stepDefinitionUsageRecorder {
+	return &stepDefinitionUsageRecorder{
+		matches: map[*dto.StepDefinition][]*stepDefinitionMatch{},
+	}
+}
+
+// record adds the steps of a test case given the step definitions that match
+// each step and the results of the steps
+func (s *stepDefinitionUsageRecorder) record(pickle *messages.Pickle, stepIndexToStepDefinitions [][]*dto.StepDefinition, testStepResults []*messages.TestResult) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for stepIndex, stepDefinitions := range stepIndexToStepDefinitions {
+		for _, stepDefinition := range stepDefinitions {
+			s.matches[stepDefinition] = append(s.matches[stepDefinition], &stepDefinitionMatch{
+				pickle: pickle,
+				step:   pickle.Steps[stepIndex],
+				result: testStepResults[stepIndex],
+			})
+		}
+	}
+}
+
+// getReport returns the usage of each of the given step definitions
+func (s *stepDefinitionUsageRecorder) getReport(baseDirectory string, stepDefinitions []*dto.StepDefinition) (*event.StepDefinitionUsage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	report := &event.StepDefinitionUsage{
+		StepDefinitions: make([]*event.StepDefinitionUsageEntry, len(stepDefinitions)),
+	}
+	for i, stepDefinition := range stepDefinitions {
+		reference, err := getStepDefinitionReference(baseDirectory, stepDefinition)
+		if err != nil {
+			return nil, err
+		}
+		entry := &event.StepDefinitionUsageEntry{
+			StepDefinitionReference: reference,
+			Matches:                 []*event.StepDefinitionUsageMatch{},
+		}
+		var totalDuration uint64
+		ranCount := 0
+		for _, match := range s.matches[stepDefinition] {
+			uri, err := getRelativeURI(baseDirectory, match.pickle.Uri)
+			if err != nil {
+				return nil, err
+			}
+			usageMatch := &event.StepDefinitionUsageMatch{
+				PickleID: match.pickle.Id,
+				URI:      uri,
+				Line:     match.step.Locations[len(match.step.Locations)-1].Line,
+				Text:     match.step.Text,
+			}
+			if match.result != nil {
+				usageMatch.Status = match.result.Status.String()
+				usageMatch.DurationNanoseconds = match.result.DurationNanoseconds
+				if hasRun(match.result) {
+					ranCount++
+					totalDuration += match.result.DurationNanoseconds
+					if match.result.DurationNanoseconds > entry.MaxDurationNanoseconds {
+						entry.MaxDurationNanoseconds = match.result.DurationNanoseconds
+					}
+				}
+			}
+			entry.Matches = append(entry.Matches, usageMatch)
+		}
+		sort.SliceStable(entry.Matches, func(i, j int) bool {
+			a, b := entry.Matches[i], entry.Matches[j]
+			if a.URI != b.URI {
+				return a.URI < b.URI
+			}
+			if a.Line != b.Line {
+				return a.Line < b.Line
+			}
+			return a.PickleID < b.PickleID
+		})
+		entry.MatchCount = len(entry.Matches)
+		entry.Unused = entry.MatchCount == 0
+		if ranCount > 0 {
+			entry.MeanDurationNanoseconds = totalDuration / uint64(ranCount)
+		}
+		report.StepDefinitions[i] = entry
+	}
+	return report, nil
+}
+
+func hasRun(result *messages.TestResult) bool {
+	switch result.Status {
+	case messages.TestResult_PASSED, messages.TestResult_FAILED, messages.TestResult_PENDING:
+		return true
+	}
+	return false
+}
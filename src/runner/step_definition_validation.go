@@ -0,0 +1,182 @@
+package runner
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"sort"
+
+	"github.com/cucumber/cucumber-engine/src/dto"
+)
+
+// The kinds of step definition problems. An overlap is found with example texts
+// of the patterns, so unlike the other kinds it is not certain
+const (
+	duplicatePatternProblem  = "duplicate"
+	equivalentPatternProblem = "equivalent"
+	overlapPatternProblem    = "overlap"
+)
+
+// maxPatternSamples limits the number of example texts generated for a pattern
+const maxPatternSamples = 32
+
+// stepDefinitionProblem is a pair of step definitions that match the same steps
+type stepDefinitionProblem struct {
+	kind            string
+	message         string
+	stepDefinitions []*dto.StepDefinition
+}
+
+// validateStepDefinitions returns the pairs of step definitions that have the same pattern,
+// compile to the same regular expression or where one matches every example text of
+// the other, in the order of the step definitions. Only the step definitions that the
+// index gives as candidates for an example text are matched against the other ones
+func validateStepDefinitions(stepDefinitions []*dto.StepDefinition, index *stepDefinitionIndex) []*stepDefinitionProblem {
+	pairToProblem := map[[2]int]*stepDefinitionProblem{}
+	regexpToIndexes := map[string][]int{}
+	for j, b := range stepDefinitions {
+		source := b.Expression.Regexp().String()
+		for _, i := range regexpToIndexes[source] {
+			a := stepDefinitions[i]
+			problem := &stepDefinitionProblem{stepDefinitions: []*dto.StepDefinition{a, b}}
+			if a.Config.GetPattern().GetType() == b.Config.GetPattern().GetType() && a.Config.GetPattern().GetSource() == b.Config.GetPattern().GetSource() {
+				problem.kind = duplicatePatternProblem
+				problem.message = fmt.Sprintf("'%s' is defined twice", a.Config.GetPattern().GetSource())
+			} else {
+				problem.kind = equivalentPatternProblem
+				problem.message = fmt.Sprintf("'%s' and '%s' are equivalent", a.Config.GetPattern().GetSource(), b.Config.GetPattern().GetSource())
+			}
+			pairToProblem[[2]int{i, j}] = problem
+		}
+		regexpToIndexes[source] = append(regexpToIndexes[source], j)
+	}
+	// the step definitions that match every example text of another one, by pair
+	overlaps := map[[2]int][]int{}
+	for j, b := range stepDefinitions {
+		samples := getPatternSamples(b)
+		if len(samples) == 0 {
+			continue
+		}
+		for _, i := range index.getCandidates(samples[0]) {
+			pair := [2]int{i, j}
+			if j < i {
+				pair = [2]int{j, i}
+			}
+			if i != j && pairToProblem[pair] == nil && matchesAll(stepDefinitions[i], samples) {
+				overlaps[pair] = append(overlaps[pair], i)
+			}
+		}
+	}
+	for pair, matchingIndexes := range overlaps {
+		a, b := stepDefinitions[pair[0]], stepDefinitions[pair[1]]
+		problem := &stepDefinitionProblem{kind: overlapPatternProblem, stepDefinitions: []*dto.StepDefinition{a, b}}
+		switch {
+		case len(matchingIndexes) == 2:
+			problem.message = fmt.Sprintf("'%s' and '%s' may match the same steps", a.Config.GetPattern().GetSource(), b.Config.GetPattern().GetSource())
+		case matchingIndexes[0] == pair[0]:
+			problem.message = fmt.Sprintf("'%s' may match every step that '%s' matches", a.Config.GetPattern().GetSource(), b.Config.GetPattern().GetSource())
+		default:
+			problem.message = fmt.Sprintf("'%s' may match every step that '%s' matches", b.Config.GetPattern().GetSource(), a.Config.GetPattern().GetSource())
+			problem.stepDefinitions = []*dto.StepDefinition{b, a}
+		}
+		pairToProblem[pair] = problem
+	}
+	pairs := make([][2]int, 0, len(pairToProblem))
+	for pair := range pairToProblem {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	problems := make([]*stepDefinitionProblem, len(pairs))
+	for i, pair := range pairs {
+		problems[i] = pairToProblem[pair]
+	}
+	return problems
+}
+
+func matchesAll(stepDefinition *dto.StepDefinition, texts []string) bool {
+	for _, text := range texts {
+		if !stepDefinition.Expression.Regexp().MatchString(text) {
+			return false
+		}
+	}
+	return true
+}
+
+// getPatternSamples returns example texts matched by the pattern of the step definition,
+// covering each alternative and optional part
+func getPatternSamples(stepDefinition *dto.StepDefinition) []string {
+	re, err := syntax.Parse(stepDefinition.Expression.Regexp().String(), syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	result := []string{}
+	for _, sample := range getRegexpSamples(re.Simplify()) {
+		if stepDefinition.Expression.Regexp().MatchString(sample) {
+			result = append(result, sample)
+		}
+	}
+	return result
+}
+
+func getRegexpSamples(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{string(re.Rune)}
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return nil
+		}
+		return []string{string(re.Rune[0])}
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return []string{"x"}
+	case syntax.OpCapture:
+		return getRegexpSamples(re.Sub[0])
+	case syntax.OpStar, syntax.OpQuest:
+		return limitSamples(append([]string{""}, getRegexpSamples(re.Sub[0])...))
+	case syntax.OpPlus:
+		return getRegexpSamples(re.Sub[0])
+	case syntax.OpRepeat:
+		result := []string{""}
+		for i := 0; i < re.Min; i++ {
+			result = concatSamples(result, getRegexpSamples(re.Sub[0]))
+		}
+		return result
+	case syntax.OpConcat:
+		result := []string{""}
+		for _, sub := range re.Sub {
+			result = concatSamples(result, getRegexpSamples(sub))
+		}
+		return result
+	case syntax.OpAlternate:
+		result := []string{}
+		for _, sub := range re.Sub {
+			result = append(result, getRegexpSamples(sub)...)
+		}
+		return limitSamples(result)
+	case syntax.OpNoMatch:
+		return nil
+	}
+	// empty match, anchors and word boundaries
+	return []string{""}
+}
+
+func concatSamples(prefixes, suffixes []string) []string {
+	result := []string{}
+	for _, prefix := range prefixes {
+		for _, suffix := range suffixes {
+			result = append(result, prefix+suffix)
+		}
+	}
+	return limitSamples(result)
+}
+
+func limitSamples(samples []string) []string {
+	if len(samples) > maxPatternSamples {
+		return samples[:maxPatternSamples]
+	}
+	return samples
+}
@@ -1,9 +1,13 @@
 package runner
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
+	"sync"
 
 	"github.com/cucumber/cucumber-engine/src/dto"
+	"github.com/cucumber/cucumber-engine/src/dto/event"
 	cucumberexpressions "github.com/cucumber/cucumber-expressions-go"
 	messages "github.com/cucumber/cucumber-messages-go/v3"
 	tagexpressions "github.com/cucumber/tag-expressions-go"
@@ -14,32 +18,81 @@ type SupportCodeLibrary struct {
 	afterTestCaseHookDefinitions  []*dto.TestCaseHookDefinition
 	beforeTestCaseHookDefinitions []*dto.TestCaseHookDefinition
 	parameterTypeRegistry         *cucumberexpressions.ParameterTypeRegistry
+	stepDefinitionIndex           *stepDefinitionIndex
+	stepDefinitionSuggester       *stepDefinitionSuggester
 	stepDefinitions               []*dto.StepDefinition
+	stepMatchesMutex              sync.Mutex
+	stepMatches                   map[string]*stepMatch
+	stepMatchCacheHits            int
+	stepMatchCacheMisses          int
 }
 
-// NewSupportCodeLibrary returns a SupportCodeLibrary for the given config
-func NewSupportCodeLibrary(config *messages.SupportCodeConfig) (*SupportCodeLibrary, error) {
-	afterTestCaseHookDefinitions, err := createTestCaseHookDefinitions(config.AfterTestCaseHookDefinitionConfigs)
-	if err != nil {
-		return nil, err
-	}
-	beforeTestCaseHookDefinitions, err := createTestCaseHookDefinitions(config.BeforeTestCaseHookDefinitionConfigs)
-	if err != nil {
-		return nil, err
+// stepMatch is the result of matching a step text, done is closed once it is computed.
+// The suggestions are only computed, once, if no step definition matches
+type stepMatch struct {
+	done               chan struct{}
+	err                error
+	patternMatches     []*messages.PatternMatch
+	patternMatchGroups []*dto.PatternMatchGroup
+	stepDefinitions    []*dto.StepDefinition
+	suggestionsOnce    sync.Once
+	suggestions        []*stepDefinitionSuggestion
+}
+
+// The kinds of support code errors
+const (
+	parameterTypeError                = "parameterType"
+	beforeTestCaseHookDefinitionError = "beforeTestCaseHookDefinition"
+	afterTestCaseHookDefinitionError  = "afterTestCaseHookDefinition"
+	stepDefinitionError               = "stepDefinition"
+)
+
+// SupportCodeConfigError lists every invalid definition of a support code config
+type SupportCodeConfigError struct {
+	Errors []*event.SupportCodeError
+}
+
+func (s *SupportCodeConfigError) Error() string {
+	lines := make([]string, len(s.Errors))
+	for i, configError := range s.Errors {
+		lines[i] = "  " + getSupportCodeErrorDescription(configError)
 	}
-	parameterTypeRegistry, err := createParameterTypeRegistry(config.ParameterTypeConfigs)
-	if err != nil {
-		return nil, err
+	return fmt.Sprintf("Invalid support code config:\n%s", strings.Join(lines, "\n"))
+}
+
+// makeURIsRelative makes the uri of each error relative to the base directory
+func (s *SupportCodeConfigError) makeURIsRelative(baseDirectory string) error {
+	for _, configError := range s.Errors {
+		uri, err := getRelativeURI(baseDirectory, configError.URI)
+		if err != nil {
+			return err
+		}
+		configError.URI = uri
 	}
-	stepDefinitions, err := createStepDefinitions(config.StepDefinitionConfigs, parameterTypeRegistry)
-	if err != nil {
-		return nil, err
+	return nil
+}
+
+// NewSupportCodeLibrary returns a SupportCodeLibrary for the given config.
+// If any definition is invalid, a *SupportCodeConfigError listing all of them is returned
+func NewSupportCodeLibrary(config *messages.SupportCodeConfig) (*SupportCodeLibrary, error) {
+	parameterTypeRegistry, parameterTypeErrors := createParameterTypeRegistry(config.ParameterTypeConfigs)
+	beforeTestCaseHookDefinitions, beforeTestCaseHookDefinitionErrors := createTestCaseHookDefinitions(config.BeforeTestCaseHookDefinitionConfigs, beforeTestCaseHookDefinitionError)
+	afterTestCaseHookDefinitions, afterTestCaseHookDefinitionErrors := createTestCaseHookDefinitions(config.AfterTestCaseHookDefinitionConfigs, afterTestCaseHookDefinitionError)
+	stepDefinitions, stepDefinitionErrors := createStepDefinitions(config.StepDefinitionConfigs, parameterTypeRegistry)
+	configErrors := append(parameterTypeErrors, beforeTestCaseHookDefinitionErrors...)
+	configErrors = append(configErrors, afterTestCaseHookDefinitionErrors...)
+	configErrors = append(configErrors, stepDefinitionErrors...)
+	if len(configErrors) > 0 {
+		return nil, &SupportCodeConfigError{Errors: configErrors}
 	}
 	return &SupportCodeLibrary{
 		afterTestCaseHookDefinitions:  afterTestCaseHookDefinitions,
 		beforeTestCaseHookDefinitions: beforeTestCaseHookDefinitions,
 		parameterTypeRegistry:         parameterTypeRegistry,
+		stepDefinitionIndex:           newStepDefinitionIndex(stepDefinitions),
+		stepDefinitionSuggester:       newStepDefinitionSuggester(stepDefinitions),
 		stepDefinitions:               stepDefinitions,
+		stepMatches:                   map[string]*stepMatch{},
 	}, nil
 }
 
@@ -53,15 +106,62 @@ func (s *SupportCodeLibrary) GetMatchingBeforeTestCaseHookDefinitions(tagNames [
 	return filterHookDefinitions(s.beforeTestCaseHookDefinitions, tagNames)
 }
 
+// GetStepDefinitions returns all the StepDefinitions
+func (s *SupportCodeLibrary) GetStepDefinitions() []*dto.StepDefinition {
+	return s.stepDefinitions
+}
+
 // GetMatchingStepDefinitions returns the StepDefinitions that match the given text
-//   the pattern matches are only returned if a single step definition matches
+// The pattern matches are only returned if a single step definition matches.
+// The result is cached for each text and shared by all test cases, the returned slices must not be modified.
+// Safe to call from multiple goroutines, a text being matched is waited for instead of matched again
 func (s *SupportCodeLibrary) GetMatchingStepDefinitions(text string) ([]*dto.StepDefinition, []*messages.PatternMatch, error) {
+	match := s.getStepMatch(text)
+	if match.err != nil {
+		return nil, nil, match.err
+	}
+	return match.stepDefinitions, match.patternMatches, nil
+}
+
+// getStepMatch returns the cached match of the text, matching it if needed
+func (s *SupportCodeLibrary) getStepMatch(text string) *stepMatch {
+	s.stepMatchesMutex.Lock()
+	match, ok := s.stepMatches[text]
+	if ok {
+		s.stepMatchCacheHits++
+		s.stepMatchesMutex.Unlock()
+		<-match.done
+	} else {
+		s.stepMatchCacheMisses++
+		match = &stepMatch{done: make(chan struct{})}
+		s.stepMatches[text] = match
+		s.stepMatchesMutex.Unlock()
+		s.matchStepDefinitions(text, match)
+		close(match.done)
+	}
+	return match
+}
+
+// GetStepMatchCacheStats returns the number of calls to GetMatchingStepDefinitions
+// that used a cached result (hits) and that had to match the text (misses)
+func (s *SupportCodeLibrary) GetStepMatchCacheStats() (int, int) {
+	s.stepMatchesMutex.Lock()
+	defer s.stepMatchesMutex.Unlock()
+	return s.stepMatchCacheHits, s.stepMatchCacheMisses
+}
+
+// matchStepDefinitions sets the step definitions that match the text on the match and,
+// if a single one matches, its pattern matches and their capture groups
+func (s *SupportCodeLibrary) matchStepDefinitions(text string, match *stepMatch) {
 	stepDefinitions := []*dto.StepDefinition{}
 	var patternMatches []*messages.PatternMatch
-	for _, def := range s.stepDefinitions {
+	var patternMatchGroups []*dto.PatternMatchGroup
+	for _, i := range s.stepDefinitionIndex.getCandidates(text) {
+		def := s.stepDefinitions[i]
 		args, err := def.Expression.Match(text)
 		if err != nil {
-			return nil, nil, err
+			match.err = err
+			return
 		}
 		if args == nil {
 			continue
@@ -69,11 +169,15 @@ func (s *SupportCodeLibrary) GetMatchingStepDefinitions(text string) ([]*dto.Ste
 		stepDefinitions = append(stepDefinitions, def)
 		if len(stepDefinitions) == 1 {
 			patternMatches = make([]*messages.PatternMatch, len(args))
+			patternMatchGroups = make([]*dto.PatternMatchGroup, len(args))
 			for i, arg := range args {
+				patternMatchGroups[i] = dto.NewPatternMatchGroup(arg.Group())
 				capturePointers := arg.Group().Values()
 				captures := make([]string, len(capturePointers))
 				for i := range capturePointers {
-					captures[i] = *capturePointers[i]
+					if capturePointers[i] != nil {
+						captures[i] = *capturePointers[i]
+					}
 				}
 				patternMatches[i] = &messages.PatternMatch{
 					Captures:          captures,
@@ -82,9 +186,28 @@ func (s *SupportCodeLibrary) GetMatchingStepDefinitions(text string) ([]*dto.Ste
 			}
 		} else {
 			patternMatches = nil
+			patternMatchGroups = nil
 		}
 	}
-	return stepDefinitions, patternMatches, nil
+	match.stepDefinitions = stepDefinitions
+	match.patternMatches = patternMatches
+	match.patternMatchGroups = patternMatchGroups
+}
+
+// getStepDefinitionSuggestions returns the step definitions closest to a text no step definition matches.
+// The result is cached with the match of the text, the returned slice must not be modified
+func (s *SupportCodeLibrary) getStepDefinitionSuggestions(text string) []*stepDefinitionSuggestion {
+	s.stepMatchesMutex.Lock()
+	match, ok := s.stepMatches[text]
+	s.stepMatchesMutex.Unlock()
+	if !ok {
+		return s.stepDefinitionSuggester.getSuggestions(text)
+	}
+	<-match.done
+	match.suggestionsOnce.Do(func() {
+		match.suggestions = s.stepDefinitionSuggester.getSuggestions(text)
+	})
+	return match.suggestions
 }
 
 // GenerateExpressions returns the generated expressions for an undefined step
@@ -115,67 +238,89 @@ func filterHookDefinitions(hookDefinitions []*dto.TestCaseHookDefinition, tagNam
 	return result
 }
 
-func createParameterTypeRegistry(parameterTypeConfigs []*messages.ParameterTypeConfig) (*cucumberexpressions.ParameterTypeRegistry, error) {
+func createParameterTypeRegistry(parameterTypeConfigs []*messages.ParameterTypeConfig) (*cucumberexpressions.ParameterTypeRegistry, []*event.SupportCodeError) {
 	parameterTypeRegistry := cucumberexpressions.NewParameterTypeRegistry()
+	configErrors := []*event.SupportCodeError{}
 	for _, parameterTypeConfig := range parameterTypeConfigs {
-		regexps := make([]*regexp.Regexp, len(parameterTypeConfig.RegularExpressions))
-		for i, regexpSource := range parameterTypeConfig.RegularExpressions {
-			var err error
-			regexps[i], err = regexp.Compile(regexpSource)
-			if err != nil {
-				// TODO wrap error with parameterType name
-				return nil, err
-			}
-		}
-		parameterType, err := cucumberexpressions.NewParameterType(
-			parameterTypeConfig.Name,
-			regexps,
-			"",
-			nil,
-			parameterTypeConfig.UseForSnippets,
-			parameterTypeConfig.PreferForRegularExpressionMatch,
-		)
+		err := defineParameterType(parameterTypeRegistry, parameterTypeConfig)
 		if err != nil {
-			// TODO wrap error with parameterType name
-			return nil, err
+			configErrors = append(configErrors, &event.SupportCodeError{
+				Kind:              parameterTypeError,
+				ParameterTypeName: parameterTypeConfig.Name,
+				Message:           err.Error(),
+			})
 		}
-		err = parameterTypeRegistry.DefineParameterType(parameterType)
+	}
+	return parameterTypeRegistry, configErrors
+}
+
+func defineParameterType(parameterTypeRegistry *cucumberexpressions.ParameterTypeRegistry, parameterTypeConfig *messages.ParameterTypeConfig) error {
+	regexps := make([]*regexp.Regexp, len(parameterTypeConfig.RegularExpressions))
+	for i, regexpSource := range parameterTypeConfig.RegularExpressions {
+		var err error
+		regexps[i], err = regexp.Compile(regexpSource)
 		if err != nil {
-			// TODO wrap error with parameterType name
-			return nil, err
+			return err
 		}
 	}
-	return parameterTypeRegistry, nil
+	parameterType, err := cucumberexpressions.NewParameterType(
+		parameterTypeConfig.Name,
+		regexps,
+		"",
+		nil,
+		parameterTypeConfig.UseForSnippets,
+		parameterTypeConfig.PreferForRegularExpressionMatch,
+	)
+	if err != nil {
+		return err
+	}
+	return parameterTypeRegistry.DefineParameterType(parameterType)
 }
 
-func createTestCaseHookDefinitions(configs []*messages.TestCaseHookDefinitionConfig) ([]*dto.TestCaseHookDefinition, error) {
-	result := make([]*dto.TestCaseHookDefinition, len(configs))
-	for i, config := range configs {
+func createTestCaseHookDefinitions(configs []*messages.TestCaseHookDefinitionConfig, kind string) ([]*dto.TestCaseHookDefinition, []*event.SupportCodeError) {
+	result := []*dto.TestCaseHookDefinition{}
+	configErrors := []*event.SupportCodeError{}
+	for _, config := range configs {
 		tagExpression, err := tagexpressions.Parse(config.TagExpression)
 		if err != nil {
-			// TODO wrap error with tag expression and line / uri
-			return nil, err
+			configErrors = append(configErrors, &event.SupportCodeError{
+				Kind:          kind,
+				ID:            config.Id,
+				TagExpression: config.TagExpression,
+				URI:           config.GetLocation().GetUri(),
+				Line:          config.GetLocation().GetLocation().GetLine(),
+				Message:       err.Error(),
+			})
+			continue
 		}
-		result[i] = &dto.TestCaseHookDefinition{
+		result = append(result, &dto.TestCaseHookDefinition{
 			Config:        config,
 			TagExpression: tagExpression,
-		}
+		})
 	}
-	return result, nil
+	return result, configErrors
 }
 
-func createStepDefinitions(configs []*messages.StepDefinitionConfig, parameterTypeRegistry *cucumberexpressions.ParameterTypeRegistry) ([]*dto.StepDefinition, error) {
-	result := make([]*dto.StepDefinition, len(configs))
-	for i, config := range configs {
+func createStepDefinitions(configs []*messages.StepDefinitionConfig, parameterTypeRegistry *cucumberexpressions.ParameterTypeRegistry) ([]*dto.StepDefinition, []*event.SupportCodeError) {
+	result := []*dto.StepDefinition{}
+	configErrors := []*event.SupportCodeError{}
+	for _, config := range configs {
 		expression, err := dto.GetExpression(config.Pattern, parameterTypeRegistry)
 		if err != nil {
-			// TODO wrap error with pattern and line / uri
-			return nil, err
+			configErrors = append(configErrors, &event.SupportCodeError{
+				Kind:          stepDefinitionError,
+				ID:            config.Id,
+				PatternSource: config.GetPattern().GetSource(),
+				URI:           config.GetLocation().GetUri(),
+				Line:          config.GetLocation().GetLocation().GetLine(),
+				Message:       err.Error(),
+			})
+			continue
 		}
-		result[i] = &dto.StepDefinition{
+		result = append(result, &dto.StepDefinition{
 			Config:     config,
 			Expression: expression,
-		}
+		})
 	}
-	return result, nil
+	return result, configErrors
 }
@@ -1,6 +1,10 @@
 package runner_test
 
 import (
+	"fmt"
+	"sync"
+
+	"github.com/cucumber/cucumber-engine/src/dto/event"
 	"github.com/cucumber/cucumber-engine/src/runner"
 	messages "github.com/cucumber/cucumber-messages-go/v3"
 	. "github.com/onsi/ginkgo"
@@ -52,6 +56,60 @@ var _ = Describe("SupportCodeLibrary", func() {
 		Expect(err).To(HaveOccurred())
 	})
 
+	It("returns all the errors with the definition they come from", func() {
+		_, err := runner.NewSupportCodeLibrary(&messages.SupportCodeConfig{
+			ParameterTypeConfigs: []*messages.ParameterTypeConfig{
+				{Name: "color", RegularExpressions: []string{"red|(blue"}},
+				{Name: "size", RegularExpressions: []string{"small|large"}},
+			},
+			BeforeTestCaseHookDefinitionConfigs: []*messages.TestCaseHookDefinitionConfig{
+				{
+					Id:            "beforeHook1",
+					TagExpression: "@tagA @tagB",
+					Location:      &messages.SourceReference{Uri: "hooks.js", Location: &messages.Location{Line: 1}},
+				},
+			},
+			AfterTestCaseHookDefinitionConfigs: []*messages.TestCaseHookDefinitionConfig{
+				{Id: "afterHook1", TagExpression: "@tagA"},
+			},
+			StepDefinitionConfigs: []*messages.StepDefinitionConfig{
+				{
+					Id:       "step1",
+					Pattern:  &messages.StepDefinitionPattern{Type: messages.StepDefinitionPatternType_CUCUMBER_EXPRESSION, Source: "a {size} step"},
+					Location: &messages.SourceReference{Uri: "steps.js", Location: &messages.Location{Line: 1}},
+				},
+				{
+					Id:       "step2",
+					Pattern:  &messages.StepDefinitionPattern{Type: messages.StepDefinitionPatternType_CUCUMBER_EXPRESSION, Source: "a {color} step"},
+					Location: &messages.SourceReference{Uri: "steps.js", Location: &messages.Location{Line: 2}},
+				},
+				{
+					Id:       "step3",
+					Pattern:  &messages.StepDefinitionPattern{Type: messages.StepDefinitionPatternType_REGULAR_EXPRESSION, Source: "*"},
+					Location: &messages.SourceReference{Uri: "steps.js", Location: &messages.Location{Line: 3}},
+				},
+			},
+		})
+		Expect(err).To(BeAssignableToTypeOf(&runner.SupportCodeConfigError{}))
+		configErrors := err.(*runner.SupportCodeConfigError).Errors
+		Expect(configErrors).To(HaveLen(4))
+		Expect(configErrors[0]).To(Equal(&event.SupportCodeError{
+			Kind:              "parameterType",
+			ParameterTypeName: "color",
+			Message:           "error parsing regexp: missing closing ): `red|(blue`",
+		}))
+		Expect(configErrors[1].Kind).To(Equal("beforeTestCaseHookDefinition"))
+		Expect(configErrors[1].ID).To(Equal("beforeHook1"))
+		Expect(configErrors[2].Kind).To(Equal("stepDefinition"))
+		Expect(configErrors[2].ID).To(Equal("step2"))
+		Expect(configErrors[3].ID).To(Equal("step3"))
+		Expect(err.Error()).To(Equal("Invalid support code config:\n" +
+			"  parameter type 'color': error parsing regexp: missing closing ): `red|(blue`\n" +
+			"  before test case hook with tag expression '@tagA @tagB' (hooks.js:1): " + configErrors[1].Message + "\n" +
+			"  step definition 'a {color} step' (steps.js:2): " + configErrors[2].Message + "\n" +
+			"  step definition '*' (steps.js:3): error parsing regexp: missing argument to repetition operator: `*`"))
+	})
+
 	Describe("GenerateExpressions", func() {
 		It("returns the generated expressions", func() {
 			var err error
@@ -237,6 +295,95 @@ var _ = Describe("SupportCodeLibrary", func() {
 				_, _, err := library.GetMatchingStepDefinitions("I have abc")
 				Expect(err).To(HaveOccurred())
 			})
+
+			It("returns the error for a text that does not match", func() {
+				_, _, err := library.GetMatchingStepDefinitions("a step")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("with step definitions of different shapes", func() {
+			BeforeEach(func() {
+				sources := []struct {
+					patternType messages.StepDefinitionPatternType
+					source      string
+				}{
+					{messages.StepDefinitionPatternType_CUCUMBER_EXPRESSION, "I have {int} cukes"},
+					{messages.StepDefinitionPatternType_CUCUMBER_EXPRESSION, "I have {int} cukes in my belly"},
+					{messages.StepDefinitionPatternType_CUCUMBER_EXPRESSION, "{word} is my name"},
+					{messages.StepDefinitionPatternType_REGULAR_EXPRESSION, `have (\d+) cuke`},
+					{messages.StepDefinitionPatternType_REGULAR_EXPRESSION, `(?i)^i HAVE a cat$`},
+					{messages.StepDefinitionPatternType_REGULAR_EXPRESSION, `^(?:a|the) step$`},
+					{messages.StepDefinitionPatternType_REGULAR_EXPRESSION, `^a (step|stepper)$`},
+					{messages.StepDefinitionPatternType_REGULAR_EXPRESSION, `^it is(?: not)? done$`},
+				}
+				configs := make([]*messages.StepDefinitionConfig, len(sources))
+				for i, source := range sources {
+					configs[i] = &messages.StepDefinitionConfig{
+						Id:      fmt.Sprintf("step%d", i+1),
+						Pattern: &messages.StepDefinitionPattern{Type: source.patternType, Source: source.source},
+					}
+				}
+				var err error
+				library, err = runner.NewSupportCodeLibrary(&messages.SupportCodeConfig{StepDefinitionConfigs: configs})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			getIds := func(text string) []string {
+				stepDefinitions, _, err := library.GetMatchingStepDefinitions(text)
+				Expect(err).NotTo(HaveOccurred())
+				ids := []string{}
+				for _, stepDefinition := range stepDefinitions {
+					ids = append(ids, stepDefinition.Config.Id)
+				}
+				return ids
+			}
+
+			It("returns the same step definitions as matching each one", func() {
+				Expect(getIds("I have 10 cukes")).To(Equal([]string{"step1", "step4"}))
+				Expect(getIds("I have 10 cukes in my belly")).To(Equal([]string{"step2", "step4"}))
+				Expect(getIds("we have 3 cukes here")).To(Equal([]string{"step4"}))
+				Expect(getIds("Bob is my name")).To(Equal([]string{"step3"}))
+				Expect(getIds("I have a cat")).To(Equal([]string{"step5"}))
+				Expect(getIds("I HAVE A CAT")).To(Equal([]string{"step5"}))
+				Expect(getIds("the step")).To(Equal([]string{"step6"}))
+				Expect(getIds("a step")).To(Equal([]string{"step6", "step7"}))
+				Expect(getIds("a stepper")).To(Equal([]string{"step7"}))
+				Expect(getIds("it is not done")).To(Equal([]string{"step8"}))
+				Expect(getIds("it is done")).To(Equal([]string{"step8"}))
+				Expect(getIds("I have  cukes")).To(BeEmpty())
+			})
+
+			It("returns the same result for the same text", func() {
+				stepDefinitions1, patternMatches1, err := library.GetMatchingStepDefinitions("I have 10 cukes in my belly")
+				Expect(err).NotTo(HaveOccurred())
+				stepDefinitions2, patternMatches2, err := library.GetMatchingStepDefinitions("I have 10 cukes in my belly")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stepDefinitions2).To(Equal(stepDefinitions1))
+				Expect(patternMatches2).To(Equal(patternMatches1))
+				hits, misses := library.GetStepMatchCacheStats()
+				Expect(hits).To(Equal(1))
+				Expect(misses).To(Equal(1))
+			})
+
+			It("matches each text once when called from multiple goroutines", func() {
+				texts := []string{"I have 1 cukes", "I have 2 cukes", "a step"}
+				var waitGroup sync.WaitGroup
+				for i := 0; i < 30; i++ {
+					waitGroup.Add(1)
+					go func(text string) {
+						defer GinkgoRecover()
+						defer waitGroup.Done()
+						stepDefinitions, _, err := library.GetMatchingStepDefinitions(text)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(stepDefinitions).NotTo(BeEmpty())
+					}(texts[i%len(texts)])
+				}
+				waitGroup.Wait()
+				hits, misses := library.GetStepMatchCacheStats()
+				Expect(hits).To(Equal(27))
+				Expect(misses).To(Equal(3))
+			})
 		})
 	})
 })
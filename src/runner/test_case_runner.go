@@ -15,7 +15,10 @@ type NewTestCaseRunnerOptions struct {
 	SendCommand                 func(*messages.Envelope)
 	SendCommandAndAwaitResponse func(*messages.Envelope) *messages.Envelope
 	SupportCodeLibrary          *SupportCodeLibrary
+	IsCancelled                 func() bool
 	IsSkipped                   bool
+	RetryCount                  int
+	TransformParameters         bool
 }
 
 // TestCaseRunner runs a test case
@@ -23,60 +26,90 @@ type TestCaseRunner struct {
 	afterTestCaseHookDefinitions  []*dto.TestCaseHookDefinition
 	baseDirectory                 string
 	beforeTestCaseHookDefinitions []*dto.TestCaseHookDefinition
+	isCancelled                   func() bool
 	isSkipped                     bool
 	pickle                        *messages.Pickle
+	retryCount                    int
 	sendCommand                   func(*messages.Envelope)
 	sendCommandAndAwaitResponse   func(*messages.Envelope) *messages.Envelope
 	stepIndexToStepDefinitions    [][]*dto.StepDefinition
 	stepIndexToPatternMatches     [][]*messages.PatternMatch
+	stepIndexToPatternMatchGroups [][]*dto.PatternMatchGroup
 	supportCodeLibrary            *SupportCodeLibrary
+	transformParameters           bool
 
-	result *messages.TestResult
+	attempt         int
+	result          *messages.TestResult
+	testStepResults []*messages.TestResult
 }
 
 // NewTestCaseRunner returns a TestCaseRunner
 func NewTestCaseRunner(opts *NewTestCaseRunnerOptions) (*TestCaseRunner, error) {
 	stepIndexToStepDefinitions := make([][]*dto.StepDefinition, len(opts.Pickle.Steps))
 	stepIndexToPatternMatches := make([][]*messages.PatternMatch, len(opts.Pickle.Steps))
+	stepIndexToPatternMatchGroups := make([][]*dto.PatternMatchGroup, len(opts.Pickle.Steps))
 	for i, step := range opts.Pickle.Steps {
-		var err error
-		stepDefinitions, patternMatches, err := opts.SupportCodeLibrary.GetMatchingStepDefinitions(step.Text)
-		if err != nil {
-			return nil, err
+		match := opts.SupportCodeLibrary.getStepMatch(step.Text)
+		if match.err != nil {
+			return nil, match.err
 		}
-		stepIndexToStepDefinitions[i] = stepDefinitions
-		stepIndexToPatternMatches[i] = patternMatches
-	}
-	tagNames := make([]string, len(opts.Pickle.Tags))
-	for i, tag := range opts.Pickle.Tags {
-		tagNames[i] = tag.Name
+		stepIndexToStepDefinitions[i] = match.stepDefinitions
+		stepIndexToPatternMatches[i] = match.patternMatches
+		stepIndexToPatternMatchGroups[i] = match.patternMatchGroups
 	}
-	initialStatus := messages.TestResult_PASSED
-	if opts.IsSkipped {
-		initialStatus = messages.TestResult_SKIPPED
+	tagNames := getPickleTagNames(opts.Pickle)
+	isCancelled := opts.IsCancelled
+	if isCancelled == nil {
+		isCancelled = func() bool { return false }
 	}
 	return &TestCaseRunner{
 		afterTestCaseHookDefinitions:  opts.SupportCodeLibrary.GetMatchingAfterTestCaseHookDefinitions(tagNames),
 		baseDirectory:                 opts.BaseDirectory,
 		beforeTestCaseHookDefinitions: opts.SupportCodeLibrary.GetMatchingBeforeTestCaseHookDefinitions(tagNames),
+		isCancelled:                   isCancelled,
 		isSkipped:                     opts.IsSkipped,
 		pickle:                        opts.Pickle,
-		result: &messages.TestResult{
-			DurationNanoseconds: 0,
-			Status:              initialStatus,
-		},
-		sendCommand:                 opts.SendCommand,
-		sendCommandAndAwaitResponse: opts.SendCommandAndAwaitResponse,
-		stepIndexToStepDefinitions:  stepIndexToStepDefinitions,
-		stepIndexToPatternMatches:   stepIndexToPatternMatches,
-		supportCodeLibrary:          opts.SupportCodeLibrary,
+		retryCount:                    opts.RetryCount,
+		sendCommand:                   opts.SendCommand,
+		sendCommandAndAwaitResponse:   opts.SendCommandAndAwaitResponse,
+		stepIndexToStepDefinitions:    stepIndexToStepDefinitions,
+		stepIndexToPatternMatches:     stepIndexToPatternMatches,
+		stepIndexToPatternMatchGroups: stepIndexToPatternMatchGroups,
+		supportCodeLibrary:            opts.SupportCodeLibrary,
+		transformParameters:           opts.TransformParameters,
 	}, nil
 }
 
-// Run runs a test case
+// Run runs a test case, retrying it while it fails and has retries left.
+// The result of the final attempt is returned
 func (t *TestCaseRunner) Run() *messages.TestResult {
 	t.sendTestCasePreparedEvent()
+	for t.attempt = 0; ; t.attempt++ {
+		t.runAttempt()
+		// only failures are retried, undefined, ambiguous and pending steps would not
+		// behave differently on another attempt
+		willBeRetried := t.result.Status == messages.TestResult_FAILED && t.attempt < t.retryCount && !t.isCancelled()
+		if !willBeRetried {
+			return t.result
+		}
+		t.sendTestCaseRetriedEvent()
+	}
+}
+
+func (t *TestCaseRunner) runAttempt() {
+	initialStatus := messages.TestResult_PASSED
+	if t.isSkipped {
+		initialStatus = messages.TestResult_SKIPPED
+	}
+	t.result = &messages.TestResult{
+		DurationNanoseconds: 0,
+		Status:              initialStatus,
+	}
+	t.testStepResults = make([]*messages.TestResult, len(t.pickle.Steps))
 	t.sendTestCaseStartedEvent()
+	if t.retryCount > 0 {
+		t.sendTestCaseAttemptEvent()
+	}
 	if !t.isSkipped {
 		t.sendCommandAndAwaitResponse(&messages.Envelope{
 			Message: &messages.Envelope_CommandInitializeTestCase{
@@ -93,7 +126,16 @@ func (t *TestCaseRunner) Run() *messages.TestResult {
 		t.updateResult(hookOrStepResult)
 	}
 	t.sendTestCaseFinishedEvent()
-	return t.result
+}
+
+// GetStepDefinitions returns the step definitions that match each step
+func (t *TestCaseRunner) GetStepDefinitions() [][]*dto.StepDefinition {
+	return t.stepIndexToStepDefinitions
+}
+
+// GetTestStepResults returns the results of the steps of the final attempt, hooks are not included
+func (t *TestCaseRunner) GetTestStepResults() []*messages.TestResult {
+	return t.testStepResults
 }
 
 func (t *TestCaseRunner) updateResult(hookOrStepResult *messages.TestResult) {
@@ -149,15 +191,142 @@ func (t *TestCaseRunner) sendTestCaseFinishedEvent() {
 	})
 }
 
+func (t *TestCaseRunner) sendTestCaseAttemptEvent() {
+	t.sendTestCaseAttemptAttachment(event.TestCaseAttemptContentType)
+}
+
+func (t *TestCaseRunner) sendTestCaseRetriedEvent() {
+	t.sendTestCaseAttemptAttachment(event.TestCaseRetriedContentType)
+}
+
+func (t *TestCaseRunner) sendTestCaseAttemptAttachment(contentType string) {
+	attachment, err := event.NewJSONAttachment(contentType, &event.TestCaseAttempt{
+		PickleID: t.pickle.Id,
+		Attempt:  t.attempt,
+	})
+	if err != nil {
+		t.sendError(err)
+		return
+	}
+	t.sendCommand(&messages.Envelope{
+		Message: &messages.Envelope_Attachment{
+			Attachment: attachment,
+		},
+	})
+}
+
+func (t *TestCaseRunner) sendAmbiguousStepEvent(stepIndex int) {
+	stepDefinitions := t.stepIndexToStepDefinitions[stepIndex]
+	ambiguousStep := &event.AmbiguousStep{
+		PickleID:        t.pickle.Id,
+		Index:           len(t.beforeTestCaseHookDefinitions) + stepIndex,
+		StepDefinitions: make([]*event.StepDefinitionReference, len(stepDefinitions)),
+	}
+	for i, stepDefinition := range stepDefinitions {
+		reference, err := getStepDefinitionReference(t.baseDirectory, stepDefinition)
+		if err != nil {
+			t.sendError(err)
+			return
+		}
+		ambiguousStep.StepDefinitions[i] = reference
+	}
+	attachment, err := event.NewJSONAttachment(event.AmbiguousStepContentType, ambiguousStep)
+	if err != nil {
+		t.sendError(err)
+		return
+	}
+	t.sendCommand(&messages.Envelope{
+		Message: &messages.Envelope_Attachment{
+			Attachment: attachment,
+		},
+	})
+}
+
+func (t *TestCaseRunner) sendUndefinedStepEvent(stepIndex int, step *messages.Pickle_PickleStep) {
+	suggestions := t.supportCodeLibrary.getStepDefinitionSuggestions(step.Text)
+	if len(suggestions) == 0 {
+		return
+	}
+	undefinedStep := &event.UndefinedStep{
+		PickleID:    t.pickle.Id,
+		Index:       len(t.beforeTestCaseHookDefinitions) + stepIndex,
+		Suggestions: make([]*event.StepDefinitionSuggestion, len(suggestions)),
+	}
+	for i, suggestion := range suggestions {
+		reference, err := getStepDefinitionReference(t.baseDirectory, suggestion.stepDefinition)
+		if err != nil {
+			t.sendError(err)
+			return
+		}
+		undefinedStep.Suggestions[i] = &event.StepDefinitionSuggestion{
+			StepDefinitionReference: reference,
+			Kind:                    suggestion.kind,
+		}
+		if suggestion.distance != unknownSuggestionDistance {
+			distance := suggestion.distance
+			undefinedStep.Suggestions[i].Distance = &distance
+		}
+	}
+	attachment, err := event.NewJSONAttachment(event.UndefinedStepContentType, undefinedStep)
+	if err != nil {
+		t.sendError(err)
+		return
+	}
+	t.sendCommand(&messages.Envelope{
+		Message: &messages.Envelope_Attachment{
+			Attachment: attachment,
+		},
+	})
+}
+
+func (t *TestCaseRunner) sendParameterValuesEvent(stepIndex int) {
+	attachment, err := event.NewJSONAttachment(event.ParameterValuesContentType, &event.ParameterValues{
+		PickleID:         t.pickle.Id,
+		Index:            len(t.beforeTestCaseHookDefinitions) + stepIndex,
+		StepDefinitionID: t.stepIndexToStepDefinitions[stepIndex][0].Config.Id,
+		Values:           getParameterValues(t.stepIndexToPatternMatches[stepIndex]),
+	})
+	if err != nil {
+		t.sendError(err)
+		return
+	}
+	t.sendCommand(&messages.Envelope{
+		Message: &messages.Envelope_Attachment{
+			Attachment: attachment,
+		},
+	})
+}
+
+func (t *TestCaseRunner) sendError(err error) {
+	t.sendCommand(&messages.Envelope{
+		Message: &messages.Envelope_CommandError{
+			CommandError: err.Error(),
+		},
+	})
+}
+
 func (t *TestCaseRunner) sendTestCasePreparedEvent() {
+	opts := event.NewTestCasePreparedOptions{
+		AfterTestCaseHookDefinitions:  t.afterTestCaseHookDefinitions,
+		BeforeTestCaseHookDefinitions: t.beforeTestCaseHookDefinitions,
+		Pickle:                        t.pickle,
+		StepIndexToStepDefinitions:    t.stepIndexToStepDefinitions,
+		StepIndexToPatternMatches:     t.stepIndexToPatternMatches,
+		StepIndexToPatternMatchGroups: t.stepIndexToPatternMatchGroups,
+	}
 	t.sendCommand(&messages.Envelope{
 		Message: &messages.Envelope_TestCasePrepared{
-			TestCasePrepared: event.NewTestCasePrepared(event.NewTestCasePreparedOptions{
-				AfterTestCaseHookDefinitions:  t.afterTestCaseHookDefinitions,
-				BeforeTestCaseHookDefinitions: t.beforeTestCaseHookDefinitions,
-				Pickle:                        t.pickle,
-				StepIndexToStepDefinitions:    t.stepIndexToStepDefinitions,
-			}),
+			TestCasePrepared: event.NewTestCasePrepared(opts),
+		},
+	})
+	attachment, err := event.NewJSONAttachment(event.TestCasePreparedContentType, event.NewTestCasePreparedDetails(opts))
+	if err != nil {
+		t.sendError(err)
+		return
+	}
+	t.sendCommand(&messages.Envelope{
+		Message: &messages.Envelope_Attachment{
+			Attachment: attachment,
 		},
 	})
 }
@@ -188,7 +357,7 @@ func (t *TestCaseRunner) getRunHookAndStepFuncs() []func() *messages.TestResult
 
 func (t *TestCaseRunner) runHookFunc(hook *dto.TestCaseHookDefinition, isBeforeHook bool) func() *messages.TestResult {
 	return func() *messages.TestResult {
-		if t.isSkipped || (isBeforeHook && t.result.Status != messages.TestResult_PASSED) {
+		if t.isSkipped || (isBeforeHook && (t.result.Status != messages.TestResult_PASSED || t.isCancelled())) {
 			return &messages.TestResult{Status: messages.TestResult_SKIPPED}
 		}
 		command := &messages.Envelope{
@@ -223,31 +392,38 @@ func (t *TestCaseRunner) runHookFunc(hook *dto.TestCaseHookDefinition, isBeforeH
 
 func (t *TestCaseRunner) runStepFunc(stepIndex int, step *messages.Pickle_PickleStep) func() *messages.TestResult {
 	return func() *messages.TestResult {
-		if len(t.stepIndexToStepDefinitions[stepIndex]) == 0 {
-			return t.getSnippetTestResult(step)
-		}
-		if len(t.stepIndexToStepDefinitions[stepIndex]) > 1 {
-			message, err := getAmbiguousStepDefinitionsMessage(t.stepIndexToStepDefinitions[stepIndex], t.baseDirectory)
-			if err != nil {
-				t.sendCommand(&messages.Envelope{
-					Message: &messages.Envelope_CommandError{
-						CommandError: err.Error(),
-					},
-				})
-			}
-			return &messages.TestResult{
-				Status:  messages.TestResult_AMBIGUOUS,
-				Message: message,
-			}
+		result := t.getStepTestResult(stepIndex, step)
+		t.testStepResults[stepIndex] = result
+		return result
+	}
+}
+
+func (t *TestCaseRunner) getStepTestResult(stepIndex int, step *messages.Pickle_PickleStep) *messages.TestResult {
+	if len(t.stepIndexToStepDefinitions[stepIndex]) == 0 {
+		t.sendUndefinedStepEvent(stepIndex, step)
+		return t.getSnippetTestResult(step)
+	}
+	if len(t.stepIndexToStepDefinitions[stepIndex]) > 1 {
+		t.sendAmbiguousStepEvent(stepIndex)
+		message, err := getAmbiguousStepDefinitionsMessage(t.stepIndexToStepDefinitions[stepIndex], t.baseDirectory)
+		if err != nil {
+			t.sendError(err)
 		}
-		if t.result.Status != messages.TestResult_PASSED {
-			return &messages.TestResult{Status: messages.TestResult_SKIPPED}
+		return &messages.TestResult{
+			Status:  messages.TestResult_AMBIGUOUS,
+			Message: message,
 		}
-		return t.getRunStepTestResult(stepIndex, step)
 	}
+	if t.result.Status != messages.TestResult_PASSED || t.isCancelled() {
+		return &messages.TestResult{Status: messages.TestResult_SKIPPED}
+	}
+	return t.getRunStepTestResult(stepIndex, step)
 }
 
 func (t *TestCaseRunner) getRunStepTestResult(stepIndex int, step *messages.Pickle_PickleStep) *messages.TestResult {
+	if t.transformParameters {
+		t.sendParameterValuesEvent(stepIndex)
+	}
 	command := t.getRunStepCommand(stepIndex, step)
 	response := t.sendCommandAndAwaitResponse(command)
 	switch x := response.Message.(type) {
@@ -284,6 +460,11 @@ func (t *TestCaseRunner) getSnippetTestResult(step *messages.Pickle_PickleStep)
 				Status:  messages.TestResult_UNDEFINED,
 				Message: y.Snippet,
 			}
+		case *messages.CommandActionComplete_TestResult:
+			// the snippet generation timed out, the step is still undefined
+			return &messages.TestResult{
+				Status: messages.TestResult_UNDEFINED,
+			}
 		}
 	}
 	panic(fmt.Sprintf("Received unexpected response (%v) to generate snippe command (%v)", response, command))
@@ -1,6 +1,11 @@
 package runner_test
 
 import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cucumber/cucumber-engine/src/dto"
+	"github.com/cucumber/cucumber-engine/src/dto/event"
 	"github.com/cucumber/cucumber-engine/src/runner"
 	"github.com/cucumber/cucumber-engine/test/helpers"
 	. "github.com/cucumber/cucumber-engine/test/matchers"
@@ -78,8 +83,8 @@ var _ = Describe("TestCaseRunner", func() {
 			}))
 		})
 
-		It("sends 7 commands", func() {
-			Expect(allMessagesSent).To(HaveLen(7))
+		It("sends 8 commands", func() {
+			Expect(allMessagesSent).To(HaveLen(8))
 		})
 
 		It("sends the test case prepared event command", func() {
@@ -104,8 +109,22 @@ var _ = Describe("TestCaseRunner", func() {
 			}))
 		})
 
-		It("sends the test case started event command", func() {
+		It("sends the test case prepared attachment", func() {
 			Expect(allMessagesSent[1]).To(Equal(&messages.Envelope{
+				Message: &messages.Envelope_Attachment{
+					Attachment: &messages.Attachment{
+						Data: `{"pickleId":"","steps":[{"pickleStepIndex":0,"stepDefinitionIds":["step1"],"patternMatches":[{"captures":["100"],"parameterTypeName":"int"}],"patternMatchGroups":[{"value":"100","start":7,"end":10}]}]}`,
+						Media: &messages.Media{
+							ContentType: event.TestCasePreparedContentType,
+							Encoding:    messages.Media_UTF8,
+						},
+					},
+				},
+			}))
+		})
+
+		It("sends the test case started event command", func() {
+			Expect(allMessagesSent[2]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_TestCaseStarted{
 					TestCaseStarted: &messages.TestCaseStarted{
 						PickleId: "",
@@ -115,7 +134,7 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 
 		It("sends the initialize test case command", func() {
-			Expect(allMessagesSent[2]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[3]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_CommandInitializeTestCase{
 					CommandInitializeTestCase: &messages.CommandInitializeTestCase{
 						Pickle: pickle,
@@ -125,7 +144,7 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 
 		It("sends the test step started event commands", func() {
-			Expect(allMessagesSent[3]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[4]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_TestStepStarted{
 					TestStepStarted: &messages.TestStepStarted{
 						PickleId: "",
@@ -136,7 +155,7 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 
 		It("sends the run test step command", func() {
-			Expect(allMessagesSent[4]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[5]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_CommandRunTestStep{
 					CommandRunTestStep: &messages.CommandRunTestStep{
 						StepDefinitionId: "step1",
@@ -152,7 +171,7 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 
 		It("sends the test step finished event command", func() {
-			Expect(allMessagesSent[5]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[6]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_TestStepFinished{
 					TestStepFinished: &messages.TestStepFinished{
 						PickleId: "",
@@ -167,7 +186,7 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 
 		It("sends the test case finished event command", func() {
-			Expect(allMessagesSent[6]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[7]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_TestCaseFinished{
 					TestCaseFinished: &messages.TestCaseFinished{
 						PickleId: "",
@@ -181,6 +200,116 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 	})
 
+	Context("with a passing step and transformed parameters", func() {
+		var allMessagesSent []*messages.Envelope
+
+		BeforeEach(func() {
+			allMessagesSent = []*messages.Envelope{}
+			sendCommand := func(command *messages.Envelope) {
+				allMessagesSent = append(allMessagesSent, command)
+			}
+			sendCommandAndAwaitResponse := func(incoming *messages.Envelope) *messages.Envelope {
+				sendCommand(incoming)
+				switch x := incoming.Message.(type) {
+				case *messages.Envelope_CommandRunTestStep:
+					return helpers.CreateActionCompleteMessageWithTestResult(
+						x.CommandRunTestStep.ActionId,
+						&messages.TestResult{Status: messages.TestResult_PASSED},
+					)
+				default:
+					return helpers.CreateActionCompleteMessage("")
+				}
+			}
+			supportCodeLibrary, err := runner.NewSupportCodeLibrary(&messages.SupportCodeConfig{
+				ParameterTypeConfigs: []*messages.ParameterTypeConfig{
+					{Name: "color", RegularExpressions: []string{"red|blue"}},
+				},
+				StepDefinitionConfigs: []*messages.StepDefinitionConfig{
+					{
+						Id: "step1",
+						Pattern: &messages.StepDefinitionPattern{
+							Source: "I have {int} {color} cukes named {string} in a {word} costing {float}",
+							Type:   messages.StepDefinitionPatternType_CUCUMBER_EXPRESSION,
+						},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			testCaseRunner, err := runner.NewTestCaseRunner(&runner.NewTestCaseRunnerOptions{
+				Pickle: &messages.Pickle{
+					Locations: []*messages.Location{{Line: 1}},
+					Steps: []*messages.Pickle_PickleStep{
+						{
+							Locations: []*messages.Location{{Line: 2}},
+							Text:      `I have 0100 red cukes named "Bob \"B\"" in a box costing .5`,
+						},
+					},
+					Uri: "/path/to/feature",
+				},
+				SendCommand:                 sendCommand,
+				SendCommandAndAwaitResponse: sendCommandAndAwaitResponse,
+				SupportCodeLibrary:          supportCodeLibrary,
+				TransformParameters:         true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			testCaseRunner.Run()
+		})
+
+		It("sends the parameter values attachment before running the step", func() {
+			Expect(allMessagesSent).To(HaveLen(9))
+			Expect(allMessagesSent[5]).To(Equal(&messages.Envelope{
+				Message: &messages.Envelope_Attachment{
+					Attachment: &messages.Attachment{
+						Data: `{"pickleId":"","index":0,"stepDefinitionId":"step1","values":[100,null,"Bob \"B\"","box",0.5]}`,
+						Media: &messages.Media{
+							ContentType: event.ParameterValuesContentType,
+							Encoding:    messages.Media_UTF8,
+						},
+					},
+				},
+			}))
+			Expect(allMessagesSent[6]).To(BeAMessageOfType(&messages.CommandRunTestStep{}))
+			Expect(allMessagesSent[6].GetCommandRunTestStep().PatternMatches).To(Equal([]*messages.PatternMatch{
+				{Captures: []string{"0100"}, ParameterTypeName: "int"},
+				{Captures: []string{"red"}, ParameterTypeName: "color"},
+				{Captures: []string{`Bob \"B\"`, ""}, ParameterTypeName: "string"},
+				{Captures: []string{"box"}, ParameterTypeName: "word"},
+				{Captures: []string{".5"}, ParameterTypeName: "float"},
+			}))
+		})
+
+		It("sends the nested capture groups of the pattern matches in the test case prepared attachment", func() {
+			details := &event.TestCasePreparedDetails{}
+			Expect(json.Unmarshal([]byte(allMessagesSent[1].GetAttachment().Data), details)).To(Succeed())
+			groups := details.Steps[0].PatternMatchGroups
+			Expect(groups).To(HaveLen(5))
+			value := func(s string) *string { return &s }
+			Expect(groups[0]).To(Equal(&dto.PatternMatchGroup{Value: value("0100"), Start: 7, End: 11}))
+			Expect(groups[2]).To(Equal(&dto.PatternMatchGroup{
+				Value: value(`"Bob \"B\""`),
+				Start: 28,
+				End:   39,
+				Children: []*dto.PatternMatchGroup{
+					{
+						Value: value(`Bob \"B\"`),
+						Start: 29,
+						End:   38,
+						Children: []*dto.PatternMatchGroup{
+							{Value: value(`\"`), Start: 36, End: 38},
+						},
+					},
+					{
+						Start: -1,
+						End:   -1,
+						Children: []*dto.PatternMatchGroup{
+							{Start: -1, End: -1},
+						},
+					},
+				},
+			}))
+		})
+	})
+
 	Context("with a failing step", func() {
 		var allMessagesSent []*messages.Envelope
 		var result *messages.TestResult
@@ -249,19 +378,20 @@ var _ = Describe("TestCaseRunner", func() {
 			}))
 		})
 
-		It("sends 7 commands", func() {
-			Expect(allMessagesSent).To(HaveLen(7))
+		It("sends 8 commands", func() {
+			Expect(allMessagesSent).To(HaveLen(8))
 			Expect(allMessagesSent[0]).To(BeAMessageOfType(&messages.TestCasePrepared{}))
-			Expect(allMessagesSent[1]).To(BeAMessageOfType(&messages.TestCaseStarted{}))
-			Expect(allMessagesSent[2]).To(BeAMessageOfType(&messages.CommandInitializeTestCase{}))
-			Expect(allMessagesSent[3]).To(BeAMessageOfType(&messages.TestStepStarted{}))
-			Expect(allMessagesSent[4]).To(BeAMessageOfType(&messages.CommandRunTestStep{}))
-			Expect(allMessagesSent[5]).To(BeAMessageOfType(&messages.TestStepFinished{}))
-			Expect(allMessagesSent[6]).To(BeAMessageOfType(&messages.TestCaseFinished{}))
+			Expect(allMessagesSent[1]).To(BeAMessageOfType(&messages.Attachment{}))
+			Expect(allMessagesSent[2]).To(BeAMessageOfType(&messages.TestCaseStarted{}))
+			Expect(allMessagesSent[3]).To(BeAMessageOfType(&messages.CommandInitializeTestCase{}))
+			Expect(allMessagesSent[4]).To(BeAMessageOfType(&messages.TestStepStarted{}))
+			Expect(allMessagesSent[5]).To(BeAMessageOfType(&messages.CommandRunTestStep{}))
+			Expect(allMessagesSent[6]).To(BeAMessageOfType(&messages.TestStepFinished{}))
+			Expect(allMessagesSent[7]).To(BeAMessageOfType(&messages.TestCaseFinished{}))
 		})
 
 		It("sends the test step finished event command with status failed", func() {
-			Expect(allMessagesSent[5]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[6]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_TestStepFinished{
 					TestStepFinished: &messages.TestStepFinished{
 						PickleId: "",
@@ -277,7 +407,7 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 
 		It("sends the test case finished event command with status failed", func() {
-			Expect(allMessagesSent[6]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[7]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_TestCaseFinished{
 					TestCaseFinished: &messages.TestCaseFinished{
 						PickleId: "",
@@ -322,7 +452,7 @@ var _ = Describe("TestCaseRunner", func() {
 						},
 					},
 					{
-						Id: "step1",
+						Id: "step2",
 						Pattern: &messages.StepDefinitionPattern{
 							Source: `^I have (\d+) cukes$`,
 							Type:   messages.StepDefinitionPatternType_REGULAR_EXPRESSION,
@@ -361,14 +491,16 @@ var _ = Describe("TestCaseRunner", func() {
 			}))
 		})
 
-		It("sends 6 commands", func() {
-			Expect(allMessagesSent).To(HaveLen(6))
+		It("sends 8 commands", func() {
+			Expect(allMessagesSent).To(HaveLen(8))
 			Expect(allMessagesSent[0]).To(BeAMessageOfType(&messages.TestCasePrepared{}))
-			Expect(allMessagesSent[1]).To(BeAMessageOfType(&messages.TestCaseStarted{}))
-			Expect(allMessagesSent[2]).To(BeAMessageOfType(&messages.CommandInitializeTestCase{}))
-			Expect(allMessagesSent[3]).To(BeAMessageOfType(&messages.TestStepStarted{}))
-			Expect(allMessagesSent[4]).To(BeAMessageOfType(&messages.TestStepFinished{}))
-			Expect(allMessagesSent[5]).To(BeAMessageOfType(&messages.TestCaseFinished{}))
+			Expect(allMessagesSent[1]).To(BeAMessageOfType(&messages.Attachment{}))
+			Expect(allMessagesSent[2]).To(BeAMessageOfType(&messages.TestCaseStarted{}))
+			Expect(allMessagesSent[3]).To(BeAMessageOfType(&messages.CommandInitializeTestCase{}))
+			Expect(allMessagesSent[4]).To(BeAMessageOfType(&messages.TestStepStarted{}))
+			Expect(allMessagesSent[5]).To(BeAMessageOfType(&messages.Attachment{}))
+			Expect(allMessagesSent[6]).To(BeAMessageOfType(&messages.TestStepFinished{}))
+			Expect(allMessagesSent[7]).To(BeAMessageOfType(&messages.TestCaseFinished{}))
 		})
 
 		It("sends the test case prepared event command without an action location", func() {
@@ -390,7 +522,7 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 
 		It("sends the test step finished event command", func() {
-			Expect(allMessagesSent[4]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[6]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_TestStepFinished{
 					TestStepFinished: &messages.TestStepFinished{
 						PickleId: "",
@@ -405,7 +537,7 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 
 		It("sends the test case finished event command", func() {
-			Expect(allMessagesSent[5]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[7]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_TestCaseFinished{
 					TestCaseFinished: &messages.TestCaseFinished{
 						PickleId: "",
@@ -417,6 +549,22 @@ var _ = Describe("TestCaseRunner", func() {
 				},
 			}))
 		})
+
+		It("sends the ambiguous step attachment", func() {
+			Expect(allMessagesSent[5]).To(Equal(&messages.Envelope{
+				Message: &messages.Envelope_Attachment{
+					Attachment: &messages.Attachment{
+						Data: `{"pickleId":"","index":0,"stepDefinitions":[` +
+							`{"id":"step1","patternSource":"I have {int} cukes","patternType":"CUCUMBER_EXPRESSION","uri":"/path/to/steps","line":3},` +
+							`{"id":"step2","patternSource":"^I have (\\d+) cukes$","patternType":"REGULAR_EXPRESSION","uri":"/path/to/steps","line":4}]}`,
+						Media: &messages.Media{
+							ContentType: event.AmbiguousStepContentType,
+							Encoding:    messages.Media_UTF8,
+						},
+					},
+				},
+			}))
+		})
 	})
 
 	Context("with a ambiguous step and base directory", func() {
@@ -446,7 +594,7 @@ var _ = Describe("TestCaseRunner", func() {
 						},
 					},
 					{
-						Id: "step1",
+						Id: "step2",
 						Pattern: &messages.StepDefinitionPattern{
 							Source: `^I have (\d+) cukes$`,
 							Type:   messages.StepDefinitionPatternType_REGULAR_EXPRESSION,
@@ -488,14 +636,32 @@ var _ = Describe("TestCaseRunner", func() {
 			}))
 		})
 
-		It("sends 6 commands", func() {
-			Expect(allMessagesSent).To(HaveLen(6))
+		It("sends 8 commands", func() {
+			Expect(allMessagesSent).To(HaveLen(8))
 			Expect(allMessagesSent[0]).To(BeAMessageOfType(&messages.TestCasePrepared{}))
-			Expect(allMessagesSent[1]).To(BeAMessageOfType(&messages.TestCaseStarted{}))
-			Expect(allMessagesSent[2]).To(BeAMessageOfType(&messages.CommandInitializeTestCase{}))
-			Expect(allMessagesSent[3]).To(BeAMessageOfType(&messages.TestStepStarted{}))
-			Expect(allMessagesSent[4]).To(BeAMessageOfType(&messages.TestStepFinished{}))
-			Expect(allMessagesSent[5]).To(BeAMessageOfType(&messages.TestCaseFinished{}))
+			Expect(allMessagesSent[1]).To(BeAMessageOfType(&messages.Attachment{}))
+			Expect(allMessagesSent[2]).To(BeAMessageOfType(&messages.TestCaseStarted{}))
+			Expect(allMessagesSent[3]).To(BeAMessageOfType(&messages.CommandInitializeTestCase{}))
+			Expect(allMessagesSent[4]).To(BeAMessageOfType(&messages.TestStepStarted{}))
+			Expect(allMessagesSent[5]).To(BeAMessageOfType(&messages.Attachment{}))
+			Expect(allMessagesSent[6]).To(BeAMessageOfType(&messages.TestStepFinished{}))
+			Expect(allMessagesSent[7]).To(BeAMessageOfType(&messages.TestCaseFinished{}))
+		})
+
+		It("sends the ambiguous step attachment", func() {
+			Expect(allMessagesSent[5]).To(Equal(&messages.Envelope{
+				Message: &messages.Envelope_Attachment{
+					Attachment: &messages.Attachment{
+						Data: `{"pickleId":"","index":0,"stepDefinitions":[` +
+							`{"id":"step1","patternSource":"I have {int} cukes","patternType":"CUCUMBER_EXPRESSION","uri":"path/to/steps","line":3},` +
+							`{"id":"step2","patternSource":"^I have (\\d+) cukes$","patternType":"REGULAR_EXPRESSION","uri":"path/to/steps","line":4}]}`,
+						Media: &messages.Media{
+							ContentType: event.AmbiguousStepContentType,
+							Encoding:    messages.Media_UTF8,
+						},
+					},
+				},
+			}))
 		})
 	})
 
@@ -549,15 +715,16 @@ var _ = Describe("TestCaseRunner", func() {
 			}))
 		})
 
-		It("sends 7 commands", func() {
-			Expect(allMessagesSent).To(HaveLen(7))
+		It("sends 8 commands", func() {
+			Expect(allMessagesSent).To(HaveLen(8))
 			Expect(allMessagesSent[0]).To(BeAMessageOfType(&messages.TestCasePrepared{}))
-			Expect(allMessagesSent[1]).To(BeAMessageOfType(&messages.TestCaseStarted{}))
-			Expect(allMessagesSent[2]).To(BeAMessageOfType(&messages.CommandInitializeTestCase{}))
-			Expect(allMessagesSent[3]).To(BeAMessageOfType(&messages.TestStepStarted{}))
-			Expect(allMessagesSent[4]).To(BeAMessageOfType(&messages.CommandGenerateSnippet{}))
-			Expect(allMessagesSent[5]).To(BeAMessageOfType(&messages.TestStepFinished{}))
-			Expect(allMessagesSent[6]).To(BeAMessageOfType(&messages.TestCaseFinished{}))
+			Expect(allMessagesSent[1]).To(BeAMessageOfType(&messages.Attachment{}))
+			Expect(allMessagesSent[2]).To(BeAMessageOfType(&messages.TestCaseStarted{}))
+			Expect(allMessagesSent[3]).To(BeAMessageOfType(&messages.CommandInitializeTestCase{}))
+			Expect(allMessagesSent[4]).To(BeAMessageOfType(&messages.TestStepStarted{}))
+			Expect(allMessagesSent[5]).To(BeAMessageOfType(&messages.CommandGenerateSnippet{}))
+			Expect(allMessagesSent[6]).To(BeAMessageOfType(&messages.TestStepFinished{}))
+			Expect(allMessagesSent[7]).To(BeAMessageOfType(&messages.TestCaseFinished{}))
 		})
 
 		It("sends the test case prepared event command without an action location", func() {
@@ -579,7 +746,7 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 
 		It("sends the generate snippet command", func() {
-			Expect(allMessagesSent[4]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[5]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_CommandGenerateSnippet{
 					CommandGenerateSnippet: &messages.CommandGenerateSnippet{
 						GeneratedExpressions: []*messages.GeneratedExpression{
@@ -594,7 +761,7 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 
 		It("sends the test step finished event command with status undefined", func() {
-			Expect(allMessagesSent[5]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[6]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_TestStepFinished{
 					TestStepFinished: &messages.TestStepFinished{
 						Index: 0,
@@ -608,7 +775,7 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 
 		It("sends the test case finished event command with status undefined", func() {
-			Expect(allMessagesSent[6]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[7]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_TestCaseFinished{
 					TestCaseFinished: &messages.TestCaseFinished{
 						TestResult: &messages.TestResult{
@@ -621,6 +788,141 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 	})
 
+	Context("with a undefined step close to step definitions", func() {
+		var allMessagesSent []*messages.Envelope
+
+		BeforeEach(func() {
+			allMessagesSent = []*messages.Envelope{}
+			sendCommand := func(incoming *messages.Envelope) {
+				allMessagesSent = append(allMessagesSent, incoming)
+			}
+			sendCommandAndAwaitResponse := func(incoming *messages.Envelope) *messages.Envelope {
+				sendCommand(incoming)
+				switch x := incoming.Message.(type) {
+				case *messages.Envelope_CommandGenerateSnippet:
+					return helpers.CreateActionCompleteMessageWithSnippet(x.CommandGenerateSnippet.ActionId, "snippet")
+				default:
+					return helpers.CreateActionCompleteMessage("")
+				}
+			}
+			stepDefinitionConfigs := []*messages.StepDefinitionConfig{}
+			for i, source := range []string{"I have {int} cukes", "I have many cuke", "I eat {int} cukes", "a completely different step"} {
+				stepDefinitionConfigs = append(stepDefinitionConfigs, &messages.StepDefinitionConfig{
+					Id: fmt.Sprintf("step%d", i+1),
+					Pattern: &messages.StepDefinitionPattern{
+						Source: source,
+						Type:   messages.StepDefinitionPatternType_CUCUMBER_EXPRESSION,
+					},
+					Location: &messages.SourceReference{
+						Uri:      "/path/to/steps",
+						Location: &messages.Location{Line: uint32(i + 1)},
+					},
+				})
+			}
+			supportCodeLibrary, err := runner.NewSupportCodeLibrary(&messages.SupportCodeConfig{
+				StepDefinitionConfigs: stepDefinitionConfigs,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			testCaseRunner, err := runner.NewTestCaseRunner(&runner.NewTestCaseRunnerOptions{
+				Pickle: &messages.Pickle{
+					Locations: []*messages.Location{{Line: 1}},
+					Steps: []*messages.Pickle_PickleStep{
+						{
+							Locations: []*messages.Location{{Line: 2}},
+							Text:      "I have many cukes",
+						},
+					},
+					Uri: "/path/to/feature",
+				},
+				SendCommand:                 sendCommand,
+				SendCommandAndAwaitResponse: sendCommandAndAwaitResponse,
+				SupportCodeLibrary:          supportCodeLibrary,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			testCaseRunner.Run()
+		})
+
+		It("sends 9 commands", func() {
+			Expect(allMessagesSent).To(HaveLen(9))
+			Expect(allMessagesSent[4]).To(BeAMessageOfType(&messages.TestStepStarted{}))
+			Expect(allMessagesSent[5]).To(BeAMessageOfType(&messages.Attachment{}))
+			Expect(allMessagesSent[6]).To(BeAMessageOfType(&messages.CommandGenerateSnippet{}))
+			Expect(allMessagesSent[7]).To(BeAMessageOfType(&messages.TestStepFinished{}))
+		})
+
+		It("sends the undefined step attachment with the parameter mismatches first", func() {
+			Expect(allMessagesSent[5]).To(Equal(&messages.Envelope{
+				Message: &messages.Envelope_Attachment{
+					Attachment: &messages.Attachment{
+						Data: `{"pickleId":"","index":0,"suggestions":[` +
+							`{"id":"step1","patternSource":"I have {int} cukes","patternType":"CUCUMBER_EXPRESSION","uri":"/path/to/steps","line":1,"kind":"parameter","distance":4},` +
+							`{"id":"step2","patternSource":"I have many cuke","patternType":"CUCUMBER_EXPRESSION","uri":"/path/to/steps","line":2,"kind":"text","distance":1}]}`,
+						Media: &messages.Media{
+							ContentType: event.UndefinedStepContentType,
+							Encoding:    messages.Media_UTF8,
+						},
+					},
+				},
+			}))
+		})
+	})
+
+	Context("with a undefined step close to a step definition without example texts", func() {
+		var allMessagesSent []*messages.Envelope
+
+		BeforeEach(func() {
+			allMessagesSent = []*messages.Envelope{}
+			sendCommand := func(incoming *messages.Envelope) {
+				allMessagesSent = append(allMessagesSent, incoming)
+			}
+			sendCommandAndAwaitResponse := func(incoming *messages.Envelope) *messages.Envelope {
+				sendCommand(incoming)
+				switch x := incoming.Message.(type) {
+				case *messages.Envelope_CommandGenerateSnippet:
+					return helpers.CreateActionCompleteMessageWithSnippet(x.CommandGenerateSnippet.ActionId, "snippet")
+				default:
+					return helpers.CreateActionCompleteMessage("")
+				}
+			}
+			supportCodeLibrary, err := runner.NewSupportCodeLibrary(&messages.SupportCodeConfig{
+				StepDefinitionConfigs: []*messages.StepDefinitionConfig{
+					{
+						Id: "step1",
+						Pattern: &messages.StepDefinitionPattern{
+							Source: `^I have (\d+)\b\d cukes$`,
+							Type:   messages.StepDefinitionPatternType_REGULAR_EXPRESSION,
+						},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			testCaseRunner, err := runner.NewTestCaseRunner(&runner.NewTestCaseRunnerOptions{
+				Pickle: &messages.Pickle{
+					Locations: []*messages.Location{{Line: 1}},
+					Steps: []*messages.Pickle_PickleStep{
+						{
+							Locations: []*messages.Location{{Line: 2}},
+							Text:      "I have many 5 cukes",
+						},
+					},
+					Uri: "/path/to/feature",
+				},
+				SendCommand:                 sendCommand,
+				SendCommandAndAwaitResponse: sendCommandAndAwaitResponse,
+				SupportCodeLibrary:          supportCodeLibrary,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			testCaseRunner.Run()
+		})
+
+		It("sends the undefined step attachment without a distance", func() {
+			Expect(allMessagesSent[5].GetAttachment().Data).To(Equal(
+				`{"pickleId":"","index":0,"suggestions":[` +
+					`{"id":"step1","patternSource":"^I have (\\d+)\\b\\d cukes$","patternType":"REGULAR_EXPRESSION","kind":"parameter"}]}`,
+			))
+		})
+	})
+
 	Context("with a failing and then skipped step", func() {
 		var allMessagesSent []*messages.Envelope
 		var result *messages.TestResult
@@ -693,21 +995,22 @@ var _ = Describe("TestCaseRunner", func() {
 			}))
 		})
 
-		It("sends 9 commands", func() {
-			Expect(allMessagesSent).To(HaveLen(9))
+		It("sends 10 commands", func() {
+			Expect(allMessagesSent).To(HaveLen(10))
 			Expect(allMessagesSent[0]).To(BeAMessageOfType(&messages.TestCasePrepared{}))
-			Expect(allMessagesSent[1]).To(BeAMessageOfType(&messages.TestCaseStarted{}))
-			Expect(allMessagesSent[2]).To(BeAMessageOfType(&messages.CommandInitializeTestCase{}))
-			Expect(allMessagesSent[3]).To(BeAMessageOfType(&messages.TestStepStarted{}))
-			Expect(allMessagesSent[4]).To(BeAMessageOfType(&messages.CommandRunTestStep{}))
-			Expect(allMessagesSent[5]).To(BeAMessageOfType(&messages.TestStepFinished{}))
-			Expect(allMessagesSent[6]).To(BeAMessageOfType(&messages.TestStepStarted{}))
-			Expect(allMessagesSent[7]).To(BeAMessageOfType(&messages.TestStepFinished{}))
-			Expect(allMessagesSent[8]).To(BeAMessageOfType(&messages.TestCaseFinished{}))
+			Expect(allMessagesSent[1]).To(BeAMessageOfType(&messages.Attachment{}))
+			Expect(allMessagesSent[2]).To(BeAMessageOfType(&messages.TestCaseStarted{}))
+			Expect(allMessagesSent[3]).To(BeAMessageOfType(&messages.CommandInitializeTestCase{}))
+			Expect(allMessagesSent[4]).To(BeAMessageOfType(&messages.TestStepStarted{}))
+			Expect(allMessagesSent[5]).To(BeAMessageOfType(&messages.CommandRunTestStep{}))
+			Expect(allMessagesSent[6]).To(BeAMessageOfType(&messages.TestStepFinished{}))
+			Expect(allMessagesSent[7]).To(BeAMessageOfType(&messages.TestStepStarted{}))
+			Expect(allMessagesSent[8]).To(BeAMessageOfType(&messages.TestStepFinished{}))
+			Expect(allMessagesSent[9]).To(BeAMessageOfType(&messages.TestCaseFinished{}))
 		})
 
 		It("sends the test step finished event command with status skipped for the second step", func() {
-			Expect(allMessagesSent[7]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[8]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_TestStepFinished{
 					TestStepFinished: &messages.TestStepFinished{
 						Index: 1,
@@ -793,21 +1096,22 @@ var _ = Describe("TestCaseRunner", func() {
 			}))
 		})
 
-		It("sends 9 commands", func() {
-			Expect(allMessagesSent).To(HaveLen(9))
+		It("sends 10 commands", func() {
+			Expect(allMessagesSent).To(HaveLen(10))
 			Expect(allMessagesSent[0]).To(BeAMessageOfType(&messages.TestCasePrepared{}))
-			Expect(allMessagesSent[1]).To(BeAMessageOfType(&messages.TestCaseStarted{}))
-			Expect(allMessagesSent[2]).To(BeAMessageOfType(&messages.TestStepStarted{}))
-			Expect(allMessagesSent[3]).To(BeAMessageOfType(&messages.TestStepFinished{}))
-			Expect(allMessagesSent[4]).To(BeAMessageOfType(&messages.TestStepStarted{}))
-			Expect(allMessagesSent[5]).To(BeAMessageOfType(&messages.TestStepFinished{}))
-			Expect(allMessagesSent[6]).To(BeAMessageOfType(&messages.TestStepStarted{}))
-			Expect(allMessagesSent[7]).To(BeAMessageOfType(&messages.TestStepFinished{}))
-			Expect(allMessagesSent[8]).To(BeAMessageOfType(&messages.TestCaseFinished{}))
+			Expect(allMessagesSent[1]).To(BeAMessageOfType(&messages.Attachment{}))
+			Expect(allMessagesSent[2]).To(BeAMessageOfType(&messages.TestCaseStarted{}))
+			Expect(allMessagesSent[3]).To(BeAMessageOfType(&messages.TestStepStarted{}))
+			Expect(allMessagesSent[4]).To(BeAMessageOfType(&messages.TestStepFinished{}))
+			Expect(allMessagesSent[5]).To(BeAMessageOfType(&messages.TestStepStarted{}))
+			Expect(allMessagesSent[6]).To(BeAMessageOfType(&messages.TestStepFinished{}))
+			Expect(allMessagesSent[7]).To(BeAMessageOfType(&messages.TestStepStarted{}))
+			Expect(allMessagesSent[8]).To(BeAMessageOfType(&messages.TestStepFinished{}))
+			Expect(allMessagesSent[9]).To(BeAMessageOfType(&messages.TestCaseFinished{}))
 		})
 
 		It("sends the test step finished event command with status skipped for the before hook", func() {
-			Expect(allMessagesSent[3]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[4]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_TestStepFinished{
 					TestStepFinished: &messages.TestStepFinished{
 						Index: 0,
@@ -820,7 +1124,7 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 
 		It("sends the test step finished event command with status skipped for the step", func() {
-			Expect(allMessagesSent[5]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[6]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_TestStepFinished{
 					TestStepFinished: &messages.TestStepFinished{
 						Index: 1,
@@ -833,7 +1137,7 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 
 		It("sends the test step finished event command with status skipped for the after hook", func() {
-			Expect(allMessagesSent[7]).To(Equal(&messages.Envelope{
+			Expect(allMessagesSent[8]).To(Equal(&messages.Envelope{
 				Message: &messages.Envelope_TestStepFinished{
 					TestStepFinished: &messages.TestStepFinished{
 						Index: 2,
@@ -846,6 +1150,188 @@ var _ = Describe("TestCaseRunner", func() {
 		})
 	})
 
+	Context("with a failing step that passes when retried", func() {
+		var allMessagesSent []*messages.Envelope
+		var result *messages.TestResult
+
+		BeforeEach(func() {
+			allMessagesSent = []*messages.Envelope{}
+			runTestStepCount := 0
+			sendCommand := func(incoming *messages.Envelope) {
+				allMessagesSent = append(allMessagesSent, incoming)
+			}
+			sendCommandAndAwaitResponse := func(incoming *messages.Envelope) *messages.Envelope {
+				sendCommand(incoming)
+				switch x := incoming.Message.(type) {
+				case *messages.Envelope_CommandRunTestStep:
+					runTestStepCount++
+					status := messages.TestResult_PASSED
+					if runTestStepCount == 1 {
+						status = messages.TestResult_FAILED
+					}
+					return helpers.CreateActionCompleteMessageWithTestResult(
+						x.CommandRunTestStep.ActionId,
+						&messages.TestResult{
+							Status:              status,
+							DurationNanoseconds: 8,
+						},
+					)
+				default:
+					return helpers.CreateActionCompleteMessage("")
+				}
+			}
+			supportCodeLibrary, err := runner.NewSupportCodeLibrary(&messages.SupportCodeConfig{
+				StepDefinitionConfigs: []*messages.StepDefinitionConfig{
+					{
+						Id: "step1",
+						Pattern: &messages.StepDefinitionPattern{
+							Source: "I have {int} cukes",
+							Type:   messages.StepDefinitionPatternType_CUCUMBER_EXPRESSION,
+						},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			testCaseRunner, err := runner.NewTestCaseRunner(&runner.NewTestCaseRunnerOptions{
+				Pickle: &messages.Pickle{
+					Id:        "pickle1",
+					Locations: []*messages.Location{{Line: 1}},
+					Steps: []*messages.Pickle_PickleStep{
+						{
+							Locations: []*messages.Location{{Line: 2}},
+							Text:      "I have 100 cukes",
+						},
+					},
+					Uri: "/path/to/feature",
+				},
+				RetryCount:                  2,
+				SendCommand:                 sendCommand,
+				SendCommandAndAwaitResponse: sendCommandAndAwaitResponse,
+				SupportCodeLibrary:          supportCodeLibrary,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			result = testCaseRunner.Run()
+		})
+
+		It("returns the result of the final attempt", func() {
+			Expect(result).To(Equal(&messages.TestResult{
+				Status:              messages.TestResult_PASSED,
+				DurationNanoseconds: 8,
+			}))
+		})
+
+		It("sends 17 commands", func() {
+			Expect(allMessagesSent).To(HaveLen(17))
+			Expect(allMessagesSent[0]).To(BeAMessageOfType(&messages.TestCasePrepared{}))
+			Expect(allMessagesSent[1]).To(BeAMessageOfType(&messages.Attachment{}))
+			Expect(allMessagesSent[2]).To(BeAMessageOfType(&messages.TestCaseStarted{}))
+			Expect(allMessagesSent[3]).To(BeAMessageOfType(&messages.Attachment{}))
+			Expect(allMessagesSent[4]).To(BeAMessageOfType(&messages.CommandInitializeTestCase{}))
+			Expect(allMessagesSent[5]).To(BeAMessageOfType(&messages.TestStepStarted{}))
+			Expect(allMessagesSent[6]).To(BeAMessageOfType(&messages.CommandRunTestStep{}))
+			Expect(allMessagesSent[7]).To(BeAMessageOfType(&messages.TestStepFinished{}))
+			Expect(allMessagesSent[8]).To(BeAMessageOfType(&messages.TestCaseFinished{}))
+			Expect(allMessagesSent[9]).To(BeAMessageOfType(&messages.Attachment{}))
+			Expect(allMessagesSent[10]).To(BeAMessageOfType(&messages.TestCaseStarted{}))
+			Expect(allMessagesSent[11]).To(BeAMessageOfType(&messages.Attachment{}))
+			Expect(allMessagesSent[12]).To(BeAMessageOfType(&messages.CommandInitializeTestCase{}))
+			Expect(allMessagesSent[13]).To(BeAMessageOfType(&messages.TestStepStarted{}))
+			Expect(allMessagesSent[14]).To(BeAMessageOfType(&messages.CommandRunTestStep{}))
+			Expect(allMessagesSent[15]).To(BeAMessageOfType(&messages.TestStepFinished{}))
+			Expect(allMessagesSent[16]).To(BeAMessageOfType(&messages.TestCaseFinished{}))
+		})
+
+		It("sends a test case attempt event after each test case started event", func() {
+			Expect(allMessagesSent[3]).To(Equal(&messages.Envelope{
+				Message: &messages.Envelope_Attachment{
+					Attachment: &messages.Attachment{
+						Data: `{"pickleId":"pickle1","attempt":0}`,
+						Media: &messages.Media{
+							ContentType: event.TestCaseAttemptContentType,
+							Encoding:    messages.Media_UTF8,
+						},
+					},
+				},
+			}))
+			Expect(allMessagesSent[11]).To(Equal(&messages.Envelope{
+				Message: &messages.Envelope_Attachment{
+					Attachment: &messages.Attachment{
+						Data: `{"pickleId":"pickle1","attempt":1}`,
+						Media: &messages.Media{
+							ContentType: event.TestCaseAttemptContentType,
+							Encoding:    messages.Media_UTF8,
+						},
+					},
+				},
+			}))
+		})
+
+		It("sends a test case retried event after the test case finished event of the retried attempt", func() {
+			Expect(allMessagesSent[9]).To(Equal(&messages.Envelope{
+				Message: &messages.Envelope_Attachment{
+					Attachment: &messages.Attachment{
+						Data: `{"pickleId":"pickle1","attempt":0}`,
+						Media: &messages.Media{
+							ContentType: event.TestCaseRetriedContentType,
+							Encoding:    messages.Media_UTF8,
+						},
+					},
+				},
+			}))
+		})
+	})
+
+	Context("with an undefined step and retries", func() {
+		var allMessagesSent []*messages.Envelope
+		var result *messages.TestResult
+
+		BeforeEach(func() {
+			allMessagesSent = []*messages.Envelope{}
+			sendCommand := func(incoming *messages.Envelope) {
+				allMessagesSent = append(allMessagesSent, incoming)
+			}
+			sendCommandAndAwaitResponse := func(incoming *messages.Envelope) *messages.Envelope {
+				sendCommand(incoming)
+				if incoming.GetCommandGenerateSnippet() != nil {
+					return helpers.CreateActionCompleteMessageWithSnippet("", "snippet")
+				}
+				return helpers.CreateActionCompleteMessage("")
+			}
+			supportCodeLibrary, err := runner.NewSupportCodeLibrary(&messages.SupportCodeConfig{})
+			Expect(err).NotTo(HaveOccurred())
+			testCaseRunner, err := runner.NewTestCaseRunner(&runner.NewTestCaseRunnerOptions{
+				Pickle: &messages.Pickle{
+					Id:        "pickle1",
+					Locations: []*messages.Location{{Line: 1}},
+					Steps: []*messages.Pickle_PickleStep{
+						{
+							Locations: []*messages.Location{{Line: 2}},
+							Text:      "I have 100 cukes",
+						},
+					},
+					Uri: "/path/to/feature",
+				},
+				RetryCount:                  2,
+				SendCommand:                 sendCommand,
+				SendCommandAndAwaitResponse: sendCommandAndAwaitResponse,
+				SupportCodeLibrary:          supportCodeLibrary,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			result = testCaseRunner.Run()
+		})
+
+		It("does not retry the test case", func() {
+			Expect(result.Status).To(Equal(messages.TestResult_UNDEFINED))
+			testCaseStartedCount := 0
+			for _, msg := range allMessagesSent {
+				if msg.GetTestCaseStarted() != nil {
+					testCaseStartedCount++
+				}
+			}
+			Expect(testCaseStartedCount).To(Equal(1))
+		})
+	})
+
 	Context("with a passing step and before hook", func() {})
 
 	Context("with a passing step and after hook", func() {})
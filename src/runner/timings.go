@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	messages "github.com/cucumber/cucumber-messages-go/v3"
+)
+
+// timings maps the location of a test case, `uri:line` with the uri relative to
+// the base directory, to its duration in nanoseconds
+type timings map[string]uint64
+
+// readTimingsFile returns the timings in the file or no timings if it does not exist
+func readTimingsFile(timingsFilePath string) (timings, error) {
+	content, err := ioutil.ReadFile(timingsFilePath)
+	if os.IsNotExist(err) {
+		return timings{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := timings{}
+	err = json.Unmarshal(content, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// writeTimingsFile writes the given timings to the file as json
+func writeTimingsFile(timingsFilePath string, t timings) error {
+	content, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(timingsFilePath, append(content, '\n'), 0644)
+}
+
+// testCaseDurations maps the id of a pickle to the duration of its test case in nanoseconds
+type testCaseDurations map[string]uint64
+
+// record sets the duration of the test case of the pickle. The durations of
+// skipped test cases are not recorded as they do not say how long they take
+func (d testCaseDurations) record(pickleID string, testCaseResult *messages.TestResult) {
+	if testCaseResult.Status != messages.TestResult_SKIPPED {
+		d[pickleID] = testCaseResult.DurationNanoseconds
+	}
+}
+
+// update sets the duration of each of the given pickles that has one
+func (t timings) update(baseDirectory string, pickles []*messages.Pickle, durations testCaseDurations) error {
+	for _, pickle := range pickles {
+		duration, ok := durations[pickle.Id]
+		if !ok {
+			continue
+		}
+		location, err := getPickleLocation(baseDirectory, pickle)
+		if err != nil {
+			return err
+		}
+		t[location] = duration
+	}
+	return nil
+}
+
+// sortLongestFirst sorts the pickles by their duration, longest first.
+// Pickles with the same duration keep their order
+func (t timings) sortLongestFirst(baseDirectory string, pickles []*messages.Pickle) error {
+	durations, err := t.estimateDurations(baseDirectory, pickles)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(pickles, func(i, j int) bool {
+		return durations[pickles[i].Id] > durations[pickles[j].Id]
+	})
+	return nil
+}
+
+// estimateDurations returns the duration of each pickle by id. Pickles without
+// a duration are given the average duration
+func (t timings) estimateDurations(baseDirectory string, pickles []*messages.Pickle) (map[string]uint64, error) {
+	durations := make(map[string]uint64, len(pickles))
+	var total uint64
+	for _, pickle := range pickles {
+		location, err := getPickleLocation(baseDirectory, pickle)
+		if err != nil {
+			return nil, err
+		}
+		if duration, ok := t[location]; ok {
+			durations[pickle.Id] = duration
+			total += duration
+		}
+	}
+	var average uint64
+	if len(durations) > 0 {
+		average = total / uint64(len(durations))
+	}
+	for _, pickle := range pickles {
+		if _, ok := durations[pickle.Id]; !ok {
+			durations[pickle.Id] = average
+		}
+	}
+	return durations, nil
+}
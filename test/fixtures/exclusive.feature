@@ -0,0 +1,15 @@
+Feature: exclusive
+  @exclusive:database
+  Scenario: E1
+    Given an expection
+
+  @exclusive:database
+  Scenario: E2
+    Given an expection
+
+  @exclusive:queue
+  Scenario: E3
+    Given an expection
+
+  Scenario: E4
+    Given an expection
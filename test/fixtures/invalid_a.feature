@@ -0,0 +1,4 @@
+Feature: invalid a
+  Scenario: IA1
+    Given a step
+  not a keyword
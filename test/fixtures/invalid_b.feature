@@ -0,0 +1,5 @@
+Feature: invalid b
+  Scenario: IB1
+    Given a step
+    | a |
+    | b | c |
@@ -0,0 +1,5 @@
+Feature: A
+  Scenario: A1
+
+  Scenario: A2
+    Given a red precondition
@@ -0,0 +1,8 @@
+Feature: outline
+  Scenario Outline: O
+    Given a <value>
+
+    Examples:
+      | value |
+      | one   |
+      | two   |
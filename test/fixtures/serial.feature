@@ -0,0 +1,13 @@
+Feature: serial
+  Scenario: S1
+    Given an expection
+
+  @serial
+  Scenario: S2
+    Given an expection
+
+  Scenario: S3
+    Given an expection
+
+  Scenario: S4
+    Given an expection
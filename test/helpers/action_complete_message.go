@@ -1,6 +1,7 @@
 package helpers
 
 import (
+	"github.com/cucumber/cucumber-engine/src/dto/event"
 	messages "github.com/cucumber/cucumber-messages-go/v3"
 )
 
@@ -42,3 +43,16 @@ func CreateActionCompleteMessageWithTestResult(actionID string, testResult *mess
 		},
 	}
 }
+
+// CreateCancelTestRunMessage returns an attachment message that cancels the test run
+func CreateCancelTestRunMessage() *messages.Envelope {
+	attachment, err := event.NewJSONAttachment(event.CancelTestRunContentType, &event.CancelTestRun{})
+	if err != nil {
+		panic(err)
+	}
+	return &messages.Envelope{
+		Message: &messages.Envelope_Attachment{
+			Attachment: attachment,
+		},
+	}
+}